@@ -5,25 +5,28 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
+	"path"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/ca0s/gitgrep/gitdown"
 	"github.com/ca0s/gitgrep/grep"
 	"github.com/ca0s/gitgrep/measure"
 
-	"github.com/flier/gohs/hyperscan"
 	"github.com/pkg/profile"
 )
 
 type (
 	MatchList struct {
 		lookForInitializations bool
-		strMatches             []string
-		matches                []*regexp.Regexp
-		hsMatchers             []*hyperscan.Pattern
+		literal                bool
+		values                 []string
+		rules                  []grep.Rule
 	}
 
 	MatchFile struct {
@@ -33,48 +36,83 @@ type (
 	URLList struct {
 		urls []string
 	}
-)
 
-func (ml *MatchList) Set(value string) error {
-	if !ml.lookForInitializations {
-		return ml.doSet(value)
+	PatternList struct {
+		patterns []string
 	}
 
-	expressions := []string{
-		`{EXP}\s*[:=]\s*`,
-		`\[{EXP}\]\s*[:=]`,
+	grepConfig struct {
+		extensions         string
+		excludedExtensions string
+		pathPatterns       PatternList
+		pathPatternsFile   string
+		useGitignore       bool
+		skipProfile        bool
+		skipPaths          PatternList
+		skipMarkers        PatternList
+		maxSize            int64
+		readLimit          int64
+		skipBinary         bool
+		chunkSize          int
+		chunkOverlap       int
+		noMmap             bool
+		archiveDepth       int
+		archiveMaxSize     int64
+		decodeDepth        int
+		extract            bool
 	}
 
-	for _, e := range expressions {
-		expr := strings.Replace(e, "{EXP}", value, -1)
-		err := ml.doSet(expr)
-		if err != nil {
-			return err
-		}
+	// fileInfo is the fs.FileInfo of files not yet downloaded.
+	fileInfo struct {
+		name string
+		size int64
 	}
+)
 
+func (ml *MatchList) Set(value string) error {
+	ml.values = append(ml.values, value)
 	return nil
 }
 
-func (ml *MatchList) doSet(value string) error {
+// Expand builds the rules to look for from the values set, which may be
+// written as /expression/flags. Keywords are expanded into the assignment
+// shapes of templates when looking for initializations, literal ones being
+// quoted into them.
+func (ml *MatchList) Expand(templates grep.Templates) error {
+	for _, value := range ml.values {
+		rule, err := grep.ParseRule(value)
+		if err != nil {
+			return err
+		}
 
-	r, err := regexp.Compile(value)
-	if err != nil {
-		return err
-	}
+		if ml.literal {
+			rule.Flags |= grep.Literal
+		}
+
+		if !ml.lookForInitializations {
+			ml.rules = append(ml.rules, rule)
+			continue
+		}
 
-	ml.strMatches = append(ml.strMatches, value)
-	ml.matches = append(ml.matches, r)
+		// templates are expressions
+		keyword, flags := rule.Expression, rule.Flags
+		if flags&grep.Literal != 0 {
+			keyword, flags = regexp.QuoteMeta(keyword), flags&^grep.Literal
+		}
 
-	hp := hyperscan.NewPattern(value, hyperscan.Caseless|hyperscan.MultiLine|hyperscan.SomLeftMost)
-	ml.hsMatchers = append(ml.hsMatchers, hp)
+		for _, expanded := range templates.Expand(keyword) {
+			expanded.Flags = flags
+			expanded.RequiredWords = rule.RequiredWords
+			ml.rules = append(ml.rules, expanded)
+		}
+	}
 
 	return nil
 }
 
 func (ml *MatchList) String() string {
 	if ml != nil {
-		return strings.Join(ml.strMatches, ", ")
+		return strings.Join(ml.values, ", ")
 	}
 	return ""
 }
@@ -114,6 +152,25 @@ func (ul *URLList) String() string {
 	return strings.Join(ul.urls, ", ")
 }
 
+func (fi fileInfo) Name() string       { return path.Base(fi.name) }
+func (fi fileInfo) Size() int64        { return fi.size }
+func (fi fileInfo) Mode() fs.FileMode  { return 0444 }
+func (fi fileInfo) ModTime() time.Time { return time.Time{} }
+func (fi fileInfo) IsDir() bool        { return false }
+func (fi fileInfo) Sys() interface{}   { return nil }
+
+func (pl *PatternList) Set(value string) error {
+	pl.patterns = append(pl.patterns, value)
+	return nil
+}
+
+func (pl *PatternList) String() string {
+	if pl != nil {
+		return strings.Join(pl.patterns, ", ")
+	}
+	return ""
+}
+
 func main() {
 	var (
 		gitLocation  string
@@ -122,16 +179,40 @@ func main() {
 		downloadMode string
 		matchMode    string
 
+		reference string
+		commit    string
+		refKinds  string
+
+		grepConf grepConfig
+		sparse   bool
+
+		submoduleDepth int
+		lfsMode        string
+
+		mirrorDir    string
+		mirrorBudget int64
+
+		memoryBudget int64
+
 		repoURLs URLList
 
 		matches MatchList = MatchList{
 			lookForInitializations: true,
 		}
-		matchFile MatchFile = MatchFile{Matches: &matches}
+		matchFile     MatchFile = MatchFile{Matches: &matches}
+		templatesFile string
+
+		useComposites  bool
+		compositesFile string
+		allowlistFile  string
+		minConfidence  float64
 
 		enablePerf             bool
 		doEvaluation           bool
 		evaluationShowFindings bool
+		showSkipped            bool
+		structured             bool
+		privateKeys            bool
 
 		downloader gitdown.GitDownloader
 		grepper    grep.Grepper
@@ -144,26 +225,138 @@ func main() {
 	flag.StringVar(&gitLocation, "git-location", "mem", "Storage for the .git data. Valid values are fs and mem")
 	flag.StringVar(&dataLocation, "data-location", "mem", "Storage for the repository contents. Valid values are fs and mem")
 	flag.Var(&repoURLs, "repo", "Repository URLs")
+	flag.StringVar(&reference, "ref", "", "Branch or tag to clone instead of the default branch")
+	flag.StringVar(&commit, "commit", "", "Exact commit to clone")
+	flag.StringVar(&refKinds, "refs", "", "Comma separated kinds of refs to clone and scan. Valid values are branches, tags and pulls")
+	flag.StringVar(&grepConf.extensions, "ext", "", "Comma separated file extensions to grep, all files are grepped if empty")
+	flag.StringVar(&grepConf.excludedExtensions, "exclude-ext", "", "Comma separated file extensions to skip")
+	flag.Var(&grepConf.pathPatterns, "path", "Gitignore style pattern of paths to skip, or to grep if prefixed with !")
+	flag.StringVar(&grepConf.pathPatternsFile, "path-file", "", "File to load gitignore style path patterns from")
+	flag.BoolVar(&grepConf.useGitignore, "gitignore", false, "Skip files ignored by the .gitignore files of the repository")
+	flag.BoolVar(&grepConf.skipProfile, "skip-profile", false, "Skip vendored, generated and lock files, as told by their paths and by markers like @generated in their head")
+	flag.Var(&grepConf.skipPaths, "skip-path", "Gitignore style pattern of paths to skip along with the skip profile, if enabled")
+	flag.Var(&grepConf.skipMarkers, "skip-marker", "Regexp to skip files whose head matches it, along with the skip profile, if enabled")
+	flag.Int64Var(&grepConf.maxSize, "max-size", 0, "Skip files bigger than this many bytes. 0 means no limit")
+	flag.Int64Var(&grepConf.readLimit, "read-limit", 0, "Only grep the first bytes of files, up to this many. 0 means no limit")
+	flag.IntVar(&grepConf.chunkSize, "chunk-size", 0, "Grep files bigger than this many bytes in chunks of this size. 0 means the default of 8 MiB")
+	flag.IntVar(&grepConf.chunkOverlap, "chunk-overlap", 64<<10, "Bytes shared by consecutive chunks, the longest match, along with the value assigned to a keyword, found across chunks")
+	flag.IntVar(&grepConf.archiveDepth, "archives", 0, "Grep inside archives and compressed files, up to this many levels of nesting. 0 disables it")
+	flag.Int64Var(&grepConf.archiveMaxSize, "archive-max-size", 256<<20, "Skip descending into zip archives whose contents are bigger than this many bytes. Tar archives and compressed files are only scanned up to this many bytes of contents")
+	flag.IntVar(&grepConf.decodeDepth, "decode", 0, "Also grep base64, hex and URL encoded data once decoded, up to this many nested encodings. 0 disables it")
+	flag.BoolVar(&grepConf.extract, "extract", false, "Grep notebooks, JSON, YAML, XML and minified JavaScript by their values, reporting where in the file each match is. Keywords are still looked for in the raw file, where their assignment templates apply")
+	flag.BoolVar(&structured, "structured", false, "Also parse .env, JSON, YAML, INI and properties files, matching keywords against key paths and reporting their values")
+	flag.BoolVar(&privateKeys, "private-keys", false, "Also look for PEM encoded private keys spanning lines, reporting their type and whether they are encrypted")
+	flag.BoolVar(&grepConf.noMmap, "no-mmap", false, "Read files into memory instead of mapping them when stored in the filesystem")
+	flag.BoolVar(&grepConf.skipBinary, "skip-binary", false, "Skip binary files: known formats like images or executables, and files with NUL bytes or mostly control characters or invalid UTF-8. UTF-16 and Latin-1 text is still grepped")
+	flag.BoolVar(&sparse, "sparse", false, "Apply the path and extension filters when writing the cloned worktree, so skipped files never take space in it. Their blobs are still downloaded, so this does not reduce the download size")
+	flag.IntVar(&submoduleDepth, "submodules", 0, "Clone submodules recursively up to this depth. 0 disables submodules")
+	flag.StringVar(&lfsMode, "lfs", "ignore", "What to do with Git LFS pointers. Valid values are ignore, report and resolve")
+	flag.StringVar(&mirrorDir, "mirror-dir", "", "Directory to keep repository mirrors in, so repeated clones only fetch changes")
+	flag.Int64Var(&mirrorBudget, "mirror-budget", 0, "Disk budget for the mirror directory in bytes, least recently used mirrors are evicted past it. 0 means no limit")
+	flag.Int64Var(&memoryBudget, "memory-budget", 0, "Memory in bytes shared by all in-memory downloads, which go to disk past it. 0 means 70% of the system memory")
+	flag.BoolVar(&showSkipped, "show-skipped", false, "List every skipped file with the reason it was skipped for")
 	flag.BoolVar(&enablePerf, "perf", false, "Measure execution performance")
 	flag.BoolVar(&doEvaluation, "evaluate", false, "Run an evaluation of performance combining all download + match modes")
 	flag.BoolVar(&evaluationShowFindings, "evaluation-findings", false, "Show findings when evaluating modes")
 
-	flag.Var(&matches, "match", "Regexps to look for in repository")
+	flag.Var(&matches, "match", "Regexps to look for in repository, or /regexp/flags with flags i for caseless, s for . matching newlines, m for multiline ^ and $ and H for a single match per file and F for a fixed string, followed by :word,word to only report matches near any of these words and @allowlist to drop the matches it allowlists")
 	flag.Var(&matchFile, "match-file", "File to load regexps from")
+	flag.BoolVar(&matches.literal, "literal", false, "Look for the matches as fixed strings instead of regexps, like the F flag of /expression/F")
+	flag.StringVar(&templatesFile, "templates", "", "File to load the assignment templates keywords are expanded into from, by file type. The built-in templates are used if empty")
+	flag.BoolVar(&useComposites, "composites", false, "Also look for the built-in composite rules, like AWS key IDs close to their secret keys, reported as a single finding")
+	flag.StringVar(&compositesFile, "composites-file", "", "File to load composite rules from instead of the built-in ones")
+	flag.StringVar(&allowlistFile, "allowlist", "", "File to load allowlists of matches known not to be secrets from. Its global allowlist applies to every match, others to the matches naming them with @allowlist")
+	flag.Float64Var(&minConfidence, "min-confidence", 0, "Drop the matches scored below this confidence, from 0 to 1, for looking like placeholders, having low entropy or being in tests and examples")
 	flag.Parse()
 
+	templates := grep.DefaultTemplates()
+	if templatesFile != "" {
+		templates, err = grep.ReadTemplates(templatesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading templates: %s\n", err)
+			return
+		}
+	}
+
+	if err := matches.Expand(templates); err != nil {
+		fmt.Fprintf(os.Stderr, "error loading matches: %s\n", err)
+		return
+	}
+
 	if enablePerf {
 		defer profile.Start(profile.MemProfileHeap, profile.MemProfileRate(1)).Stop()
 	}
 
+	if memoryBudget > 0 {
+		gitdown.DefaultMemoryBudget.SetLimit(memoryBudget)
+	}
+
 	if doEvaluation {
 		evaluateCombinations(repoURLs.urls, matches, evaluationShowFindings)
 		return
 	}
 
+	grepOptions, err := buildGrepOptions(grepConf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading grep options: %s\n", err)
+		return
+	}
+
 	switch downloadMode {
 	case "clone":
-		downloader, err = gitdown.NewCloneDownloader(gitdown.InMemory, gitdown.InMemory)
+		var cloneDownloader *gitdown.CloneDownloader
+
+		cloneDownloader, err = gitdown.NewCloneDownloader(gitdown.InMemory, gitdown.InMemory)
+		if err != nil {
+			break
+		}
+
+		kinds, kindsErr := parseRefKinds(refKinds)
+		if kindsErr != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", kindsErr)
+			flag.Usage()
+			return
+		}
+
+		lfs, lfsErr := parseLFSMode(lfsMode)
+		if lfsErr != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", lfsErr)
+			flag.Usage()
+			return
+		}
+
+		cloneDownloader.SetReference(reference)
+		cloneDownloader.SetCommit(commit)
+		cloneDownloader.SetRefKinds(kinds)
+
+		cloneDownloader.SetMaxBlobSize(grepConf.maxSize)
+		cloneDownloader.SetRecurseSubmodules(submoduleDepth)
+		cloneDownloader.SetLFSMode(lfs)
+
+		if mirrorDir != "" {
+			mirrorCache, mirrorErr := gitdown.NewMirrorCache(mirrorDir, mirrorBudget)
+			if mirrorErr != nil {
+				fmt.Fprintf(os.Stderr, "error creating mirror cache: %s\n", mirrorErr)
+				return
+			}
+
+			cloneDownloader.SetMirrorCache(mirrorCache)
+		}
+
+		if sparse {
+			cloneDownloader.SetFileFilter(func(files fs.FS) gitdown.FileFilter {
+				// options read state from the filesystem they are given, so
+				// every tree gets its own, apart from those of the grepper
+				options, _ := buildGrepOptions(grepConf)
+				for _, option := range options {
+					option.SetData(files)
+				}
+
+				return fileFilter(options)
+			})
+		}
+
+		downloader = cloneDownloader
 	case "zip":
 		downloader = gitdown.NewZipDownloader(gitdown.InMemory)
 	default:
@@ -171,14 +364,45 @@ func main() {
 		return
 	}
 
+	var composites []grep.Composite
+
+	switch {
+	case compositesFile != "":
+		composites, err = grep.ReadComposites(compositesFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading composite rules: %s\n", err)
+			return
+		}
+	case useComposites:
+		composites = grep.DefaultComposites()
+	}
+
+	// the parts of composite rules are matched along with the rest
+	rules := append(matches.rules, grep.CompositeRules(composites)...)
+
+	if allowlistFile != "" {
+		allowlists, err := grep.ReadAllowlists(allowlistFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error loading allowlists: %s\n", err)
+			return
+		}
+
+		if err := allowlists.Check(rules); err != nil {
+			fmt.Fprintf(os.Stderr, "error loading allowlists: %s\n", err)
+			return
+		}
+
+		grepOptions = append(grepOptions, grep.WithAllowlists(allowlists))
+	}
+
 	switch matchMode {
 	case "hs":
-		grepper, err = grep.NewHyperscanGrepper(matches.strMatches)
+		grepper, err = grep.NewHyperscanGrepperForRules(rules)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error initializing HyperScan grepper: %s\n", err)
 		}
 	case "re":
-		grepper = grep.NewReGrepper(matches.matches)
+		grepper, err = grep.NewReGrepperForRules(rules)
 	default:
 		flag.Usage()
 		return
@@ -189,6 +413,32 @@ func main() {
 		return
 	}
 
+	if len(composites) > 0 {
+		grepper = grep.NewCompositeGrepper(grepper, composites)
+	}
+
+	greppers := []grep.Grepper{grepper}
+
+	if structured {
+		structuredGrepper, err := grep.NewStructuredGrepper(matches.rules)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error initializing structured grepper: %s\n", err)
+			return
+		}
+
+		greppers = append(greppers, structuredGrepper)
+	}
+
+	if privateKeys {
+		greppers = append(greppers, grep.NewPEMGrepper())
+	}
+
+	if len(greppers) > 1 {
+		grepper = grep.NewMultiGrepper(greppers...)
+	}
+
+	grepper = grep.NewScoringGrepper(grepper, minConfidence)
+
 	for _, repoURL := range repoURLs.urls {
 		fmt.Printf("Downloading repo: %s, method = %s\n", repoURL, downloadMode)
 
@@ -210,7 +460,9 @@ func main() {
 
 		ms.Start()
 
-		results, err := grepper.Grep(repo.Filesystem())
+		stats := &grep.ScanStats{}
+
+		results, err := grepRepo(grepper, repo, append(grepOptions, grep.WithStats(stats)))
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error grepping: %s\n", err)
 			return
@@ -218,15 +470,245 @@ func main() {
 
 		ms.End()
 		fmt.Printf("\ttook %s\n", ms.Ellpsed())
+		fmt.Printf("\tscanned %d files (%d bytes), skipped %d, truncated %d, chunked %d, transcoded %d, archives %d\n", stats.FilesScanned, stats.BytesScanned, stats.FilesSkipped, stats.FilesTruncated, stats.FilesChunked, stats.FilesTranscoded, stats.ArchivesOpened)
+
+		if showSkipped {
+			for _, skipped := range stats.Skipped {
+				fmt.Printf("\tskipped %s: %s\n", skipped.Path, skipped.Reason)
+			}
+		} else {
+			counts := stats.SkippedByReason()
+
+			reasons := make([]string, 0, len(counts))
+			for reason := range counts {
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+
+			for _, reason := range reasons {
+				fmt.Printf("\t\t%s: %d\n", reason, counts[reason])
+			}
+		}
 
 		for _, result := range results {
-			fmt.Printf("%s: %s\n", result.Path, result.Comment)
+			comment := result.Comment
+			if len(result.Reasons) > 0 {
+				comment += fmt.Sprintf(" [confidence %.2f: %s]", result.Confidence, strings.Join(result.Reasons, ", "))
+			}
+
+			if result.Ref != "" {
+				fmt.Printf("[%s] %s: %s\n", result.Ref, result.Path, comment)
+			} else {
+				fmt.Printf("%s: %s\n", result.Path, comment)
+			}
+		}
+
+		for _, pointer := range repo.LFSPointers() {
+			if pointer.Resolved {
+				continue
+			}
+
+			if pointer.Ref != "" {
+				fmt.Printf("[%s] %s: LFS pointer to %s (%d bytes)\n", pointer.Ref, pointer.Path, pointer.OID, pointer.Size)
+			} else {
+				fmt.Printf("%s: LFS pointer to %s (%d bytes)\n", pointer.Path, pointer.OID, pointer.Size)
+			}
 		}
 
 		repo.Close()
 	}
 }
 
+func splitList(value string) []string {
+	var items []string
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func buildGrepOptions(conf grepConfig) ([]grep.GrepOption, error) {
+	var options []grep.GrepOption
+
+	if conf.maxSize > 0 {
+		options = append(options, grep.WithMaxFileSize(conf.maxSize))
+	}
+
+	if conf.readLimit > 0 {
+		options = append(options, grep.WithReadLimit(conf.readLimit))
+	}
+
+	if conf.archiveDepth > 0 {
+		options = append(options, grep.WithArchives(conf.archiveDepth, conf.archiveMaxSize))
+	}
+
+	if conf.decodeDepth > 0 {
+		options = append(options, grep.WithDecoding(conf.decodeDepth))
+	}
+
+	if conf.extract {
+		options = append(options, grep.WithExtractors(grep.DefaultExtractors()...))
+	}
+
+	if conf.noMmap {
+		options = append(options, grep.WithoutMmap())
+	}
+
+	if conf.chunkSize > 0 {
+		options = append(options, grep.WithChunkSize(conf.chunkSize, conf.chunkOverlap))
+	}
+
+	// WithFileExtensions skips the files having any of the extensions, and
+	// WithExcludedFileExtensions those having none of them
+	if exts := splitList(conf.extensions); len(exts) > 0 {
+		options = append(options, grep.WithExcludedFileExtensions(exts...))
+	}
+
+	if exts := splitList(conf.excludedExtensions); len(exts) > 0 {
+		options = append(options, grep.WithFileExtensions(exts...))
+	}
+
+	if conf.skipBinary {
+		options = append(options, grep.WithTextContent())
+	}
+
+	if conf.skipProfile || len(conf.skipPaths.patterns) > 0 || len(conf.skipMarkers.patterns) > 0 {
+		var profile grep.SkipProfile
+		if conf.skipProfile {
+			profile = grep.DefaultSkipProfile()
+		}
+
+		profile.Paths = append(profile.Paths, conf.skipPaths.patterns...)
+
+		for _, marker := range conf.skipMarkers.patterns {
+			r, err := regexp.Compile(marker)
+			if err != nil {
+				return nil, err
+			}
+
+			profile.ContentMarkers = append(profile.ContentMarkers, r)
+		}
+
+		options = append(options, grep.WithSkipProfile(profile))
+	}
+
+	var patterns []string
+
+	if conf.pathPatternsFile != "" {
+		filePatterns, err := grep.ReadPathPatterns(conf.pathPatternsFile)
+		if err != nil {
+			return nil, err
+		}
+
+		patterns = append(patterns, filePatterns...)
+	}
+
+	patterns = append(patterns, conf.pathPatterns.patterns...)
+
+	ignoreFiles := []string{grep.GitgrepIgnoreFile}
+	if conf.useGitignore {
+		ignoreFiles = append(ignoreFiles, grep.GitIgnoreFile)
+	}
+
+	options = append(options, grep.WithPathFilter(patterns, ignoreFiles))
+
+	return options, nil
+}
+
+// fileFilter adapts grep options so downloaders can skip the same files the
+// grepper would.
+func fileFilter(options []grep.GrepOption) gitdown.FileFilter {
+	return func(path string, size int64) bool {
+		info := fileInfo{name: path, size: size}
+
+		for _, option := range options {
+			if option.SkipFile(path, info) {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func parseLFSMode(value string) (gitdown.LFSMode, error) {
+	switch value {
+	case "ignore":
+		return gitdown.LFSIgnore, nil
+	case "report":
+		return gitdown.LFSReport, nil
+	case "resolve":
+		return gitdown.LFSResolve, nil
+	default:
+		return gitdown.LFSIgnore, fmt.Errorf("invalid LFS mode: %s", value)
+	}
+}
+
+func parseRefKinds(value string) (gitdown.RefKind, error) {
+	var kinds gitdown.RefKind
+
+	for _, kind := range splitList(value) {
+		switch kind {
+		case "branches":
+			kinds |= gitdown.RefBranches
+		case "tags":
+			kinds |= gitdown.RefTags
+		case "pulls":
+			kinds |= gitdown.RefPulls
+		default:
+			return 0, fmt.Errorf("invalid ref kind: %s", kind)
+		}
+	}
+
+	return kinds, nil
+}
+
+// grepRepo greps the checked out tree of repo or, if the downloader fetched
+// several refs, every one of them. Blobs shared between refs are only
+// grepped once, and their results reported for every ref they appear in.
+func grepRepo(grepper grep.Grepper, repo *gitdown.Repo, options []grep.GrepOption) ([]grep.Result, error) {
+	refs := repo.RefsFS()
+	if refs == nil {
+		results, err := grepper.Grep(repo.Filesystem(), options...)
+
+		for i := range results {
+			results[i].Ref = repo.Ref()
+		}
+
+		return results, err
+	}
+
+	results, err := grepper.Grep(refs, options...)
+
+	var expanded []grep.Result
+
+	for _, result := range results {
+		// files inside archives are located by the archive holding them
+		outer, inner := grep.SplitArchivePath(result.Path)
+
+		for _, location := range refs.Locations(outer) {
+			r := result
+			r.Ref = location.Ref
+			r.Path = location.Path
+
+			if inner != "" {
+				r.Path += grep.ArchiveSeparator + inner
+			}
+
+			r.Comment = strings.Replace(result.Comment, result.Path, r.Path, 1)
+
+			expanded = append(expanded, r)
+		}
+	}
+
+	return expanded, err
+}
+
 func evaluateCombinations(repos []string, matches MatchList, showFindings bool) {
 	type urlGenerator func(url string) string
 
@@ -284,12 +766,16 @@ func evaluateCombinations(repos []string, matches MatchList, showFindings bool)
 	zipMemoryDownloader := gitdown.NewZipDownloader(gitdown.InMemory)
 	zipFsDownloader := gitdown.NewZipDownloader(gitdown.InFilesystem)
 
-	hsGrepper, err := grep.NewHyperscanGrepper(matches.strMatches)
+	hsGrepper, err := grep.NewHyperscanGrepperForRules(matches.rules)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error initializing HyperScan grepper: %s\n", err)
 	}
 
-	reGrepper := grep.NewReGrepper(matches.matches)
+	reGrepper, err := grep.NewReGrepperForRules(matches.rules)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error initializing regexp grepper: %s\n", err)
+		return
+	}
 
 	var combinations []combination = []combination{
 		{
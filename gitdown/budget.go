@@ -0,0 +1,131 @@
+package gitdown
+
+import (
+	"math"
+	"sync"
+	"syscall"
+)
+
+// MemoryBudget is an amount of memory shared by downloads. Downloads reserve
+// memory from it before keeping data in memory, and fall back to disk when
+// the budget is exhausted. A nil budget does not limit memory.
+type MemoryBudget struct {
+	lock  sync.Mutex
+	limit int64
+	used  int64
+}
+
+// Reservation is an amount of memory taken from a MemoryBudget.
+type Reservation struct {
+	budget *MemoryBudget
+	size   int64
+	once   sync.Once
+}
+
+// DefaultMemoryBudget is the budget used by downloaders unless told
+// otherwise. It starts at 70% of the system memory.
+var DefaultMemoryBudget = NewMemoryBudget(systemMemoryLimit())
+
+func systemMemoryLimit() int64 {
+	info := syscall.Sysinfo_t{}
+	syscall.Sysinfo(&info)
+
+	return int64(info.Totalram / 10 * 7)
+}
+
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{
+		limit: limit,
+	}
+}
+
+// SetLimit changes the size of the budget. Existing reservations are kept
+// even if they exceed the new limit.
+func (b *MemoryBudget) SetLimit(limit int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.limit = limit
+}
+
+// Limit returns the size of the budget, or 0 for nil budgets, which are
+// not limited.
+func (b *MemoryBudget) Limit() int64 {
+	if b == nil {
+		return 0
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.limit
+}
+
+// Available returns the memory not reserved yet, or math.MaxInt64 for nil
+// budgets.
+func (b *MemoryBudget) Available() int64 {
+	if b == nil {
+		return math.MaxInt64
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.used >= b.limit {
+		return 0
+	}
+
+	return b.limit - b.used
+}
+
+// TryReserve reserves size bytes of the budget, returning nil if they are
+// not available. Reservations from nil budgets always succeed.
+func (b *MemoryBudget) TryReserve(size int64) *Reservation {
+	if b == nil {
+		return &Reservation{size: size}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if size < 0 || b.used+size > b.limit {
+		return nil
+	}
+
+	b.used += size
+
+	return &Reservation{
+		budget: b,
+		size:   size,
+	}
+}
+
+// Resize changes the reservation to size bytes, giving the difference back
+// to the budget or taking it from it. Reservations grow past the limit of
+// the budget, as they account for memory already in use.
+func (r *Reservation) Resize(size int64) {
+	if r == nil || r.budget == nil {
+		return
+	}
+
+	r.budget.lock.Lock()
+	defer r.budget.lock.Unlock()
+
+	r.budget.used += size - r.size
+	r.size = size
+}
+
+// Release gives the reservation back to the budget. It is safe to call it
+// more than once, and on nil reservations.
+func (r *Reservation) Release() {
+	if r == nil || r.budget == nil {
+		return
+	}
+
+	r.once.Do(func() {
+		r.budget.lock.Lock()
+		defer r.budget.lock.Unlock()
+
+		r.budget.used -= r.size
+	})
+}
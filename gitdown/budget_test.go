@@ -0,0 +1,94 @@
+package gitdown
+
+import (
+	"testing"
+
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int64
+		reserve   int64
+		resize    int64
+		ok        bool
+		available int64
+	}{
+		{name: "fits", limit: 100, reserve: 60, resize: 60, ok: true, available: 40},
+		{name: "too big", limit: 100, reserve: 101, ok: false, available: 100},
+		{name: "shrunk", limit: 100, reserve: 60, resize: 10, ok: true, available: 90},
+		{name: "grown past the limit", limit: 100, reserve: 60, resize: 150, ok: true, available: 0},
+	}
+
+	for _, test := range tests {
+		b := NewMemoryBudget(test.limit)
+
+		r := b.TryReserve(test.reserve)
+		if (r != nil) != test.ok {
+			t.Errorf("%s: TryReserve(%d) = %v, want ok %v", test.name, test.reserve, r, test.ok)
+			continue
+		}
+
+		r.Resize(test.resize)
+
+		if available := b.Available(); available != test.available {
+			t.Errorf("%s: %d bytes available, want %d", test.name, available, test.available)
+		}
+
+		r.Release()
+		r.Release()
+
+		if available := b.Available(); available != test.limit {
+			t.Errorf("%s: %d bytes available once released, want %d", test.name, available, test.limit)
+		}
+	}
+}
+
+func TestNilMemoryBudget(t *testing.T) {
+	var b *MemoryBudget
+
+	r := b.TryReserve(1 << 40)
+	if r == nil {
+		t.Fatal("nil budgets should not limit reservations")
+	}
+
+	r.Resize(10)
+	r.Release()
+
+	storage, err := createStorage(InMemory, nil, 1<<40)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if storage.Location() != InMemory {
+		t.Errorf("storage created in %v, want in memory", storage.Location())
+	}
+}
+
+func TestFitReservation(t *testing.T) {
+	b := NewMemoryBudget(1000)
+
+	storage, err := createStorage(InMemory, b, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	util.WriteFile(storage.Filesystem(), "a.txt", make([]byte, 10), 0644)
+	util.WriteFile(storage.Filesystem(), "dir/sub/b.txt", make([]byte, 20), 0644)
+
+	if err := storage.fitReservation(); err != nil {
+		t.Fatal(err)
+	}
+
+	if available := b.Available(); available != 970 {
+		t.Errorf("%d bytes available, want 970", available)
+	}
+
+	storage.Close()
+
+	if available := b.Available(); available != 1000 {
+		t.Errorf("%d bytes available once closed, want 1000", available)
+	}
+
+}
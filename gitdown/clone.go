@@ -2,15 +2,26 @@ package gitdown
 
 import (
 	"fmt"
+	"io"
+	"io/fs"
 	"net/url"
 	"os"
+	"path"
+	"strings"
 
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
 )
 
 type CloneDownloader struct {
@@ -20,13 +31,44 @@ type CloneDownloader struct {
 	progress    sideband.Progress
 	blocking    bool
 	authStorage *AuthStorage
+
+	reference string
+	commit    string
+	refKinds  RefKind
+
+	newFileFilter NewFileFilter
+	maxBlobSize   int64
+
+	submoduleDepth int
+	lfsMode        LFSMode
+
+	mirrorCache *MirrorCache
+	budget      *MemoryBudget
 }
 
+// cloneMemoryEstimate is the memory reserved for each in-memory storage of a
+// clone whose size is not known before cloning it. Reservations are resized
+// to the size of the data once it is downloaded.
+const cloneMemoryEstimate = 256 << 20
+
+// FileFilter decides whether a file of the repository, given its path and
+// size, should be written to the worktree. Filters only apply to worktrees:
+// go-git fetches whole packs, without partial clone filters, so the blobs of
+// the files filtered out are downloaded and kept in the git storage anyway.
+type FileFilter func(path string, size int64) bool
+
+// NewFileFilter creates the FileFilter for a tree about to be written, whose
+// files can be read from files, e.g. to load ignore files from it. A new
+// filter is created for every tree, with paths relative to the top level
+// one, so filters are free to keep state about the tree.
+type NewFileFilter func(files fs.FS) FileFilter
+
 func NewCloneDownloader(gitLocation DownloadLocation, dataLocation DownloadLocation) (*CloneDownloader, error) {
 	return &CloneDownloader{
 		gitLocation:  gitLocation,
 		dataLocation: dataLocation,
 		progress:     os.Stdout,
+		budget:       DefaultMemoryBudget,
 	}, nil
 }
 
@@ -34,52 +76,205 @@ func (cd *CloneDownloader) SetProgress(p sideband.Progress) {
 	cd.progress = p
 }
 
+// SetReference makes Download check out the given branch or tag instead of
+// the default branch. Both short (main, v1.0) and full (refs/heads/main)
+// names are accepted.
+func (cd *CloneDownloader) SetReference(ref string) {
+	cd.reference = ref
+}
+
+// SetCommit makes Download check out an exact commit. This requires fetching
+// the whole history of the cloned reference.
+func (cd *CloneDownloader) SetCommit(hash string) {
+	cd.commit = hash
+}
+
+// SetRefKinds makes Download fetch every ref of the given kinds, exposing
+// their trees through Repo.RefsFS.
+func (cd *CloneDownloader) SetRefKinds(kinds RefKind) {
+	cd.refKinds = kinds
+}
+
+// SetFileFilter restricts the files written to the worktree, and exposed by
+// Repo.RefsFS, to those accepted by the filters newFilter creates for every
+// tree. The blobs of the files filtered out are still fetched into the git
+// storage.
+func (cd *CloneDownloader) SetFileFilter(newFilter NewFileFilter) {
+	cd.newFileFilter = newFilter
+}
+
+// SetMaxBlobSize skips files bigger than size bytes when writing the
+// worktree. Like file filters, it does not apply to the download. Zero
+// disables the limit.
+func (cd *CloneDownloader) SetMaxBlobSize(size int64) {
+	cd.maxBlobSize = size
+}
+
+// SetRecurseSubmodules clones submodules, and their own submodules, up to
+// depth levels deep. Zero disables submodule cloning.
+func (cd *CloneDownloader) SetRecurseSubmodules(depth int) {
+	cd.submoduleDepth = depth
+}
+
+// SetLFSMode sets how Git LFS pointer files are handled.
+func (cd *CloneDownloader) SetLFSMode(mode LFSMode) {
+	cd.lfsMode = mode
+}
+
+// SetMirrorCache keeps the git data of downloaded repositories in a mirror
+// cache, so downloading them again only fetches new objects. Mirrors hold the
+// full history of every branch, and are used instead of the git location
+// storage.
+func (cd *CloneDownloader) SetMirrorCache(c *MirrorCache) {
+	cd.mirrorCache = c
+}
+
 func (cd *CloneDownloader) Download(repoURL string) (*Repo, error) {
-	storeFS, err := createStorage(cd.gitLocation)
+	repo := &Repo{}
+	estimate := int64(cloneMemoryEstimate)
+
+	var storeFS billy.Filesystem
+
+	if cd.mirrorCache != nil {
+		m, err := cd.mirrorCache.acquire(repoURL)
+		if err != nil {
+			return nil, err
+		}
+
+		defer m.unlock()
+
+		repo.releaser = m.release
+		storeFS = osfs.New(m.path)
+
+		// the git data of a mirror kept from a previous download tells the
+		// size of the repository better
+		if size, err := dirSize(m.path); err == nil && size > 0 {
+			estimate = size
+		}
+	} else {
+		storage, err := createStorage(cd.gitLocation, cd.budget, estimate)
+		if err != nil {
+			return nil, err
+		}
+
+		repo.storeFS = storage
+		storeFS = storage.Filesystem()
+	}
+
+	workFS, err := createStorage(cd.dataLocation, cd.budget, estimate)
 	if err != nil {
+		repo.Close()
 		return nil, err
 	}
 
-	workFS, err := createStorage(cd.dataLocation)
+	repo.workFS = workFS
+
+	auth, err := cd.authFor(repoURL)
 	if err != nil {
+		repo.Close()
 		return nil, err
 	}
 
-	var auth transport.AuthMethod
+	cloneOptions := &git.CloneOptions{
+		URL:      repoURL,
+		Depth:    1,
+		Progress: cd.progress,
+		Auth:     auth,
+	}
 
-	if cd.authStorage != nil {
-		u, err := url.Parse(repoURL)
+	if cd.reference != "" {
+		cloneOptions.ReferenceName, err = cd.resolveReference(repoURL, auth)
 		if err != nil {
+			repo.Close()
 			return nil, err
 		}
 
-		authData := cd.authStorage.GetSiteAuth(u.Host)
-		if authData != nil {
-			auth = &http.TokenAuth{
-				Token: authData.Value,
-			}
-		}
+		cloneOptions.SingleBranch = true
+	}
+
+	if cd.commit != "" {
+		cloneOptions.Depth = 0
+		cloneOptions.NoCheckout = true
+	}
+
+	if cd.isSparse() {
+		cloneOptions.NoCheckout = true
+	}
+
+	if cd.refKinds != 0 {
+		cloneOptions.SingleBranch = false
+		cloneOptions.Tags = git.NoTags
 
+		if cd.refKinds&RefTags != 0 {
+			cloneOptions.Tags = git.AllTags
+		}
 	}
 
-	_, err = git.Clone(
-		filesystem.NewStorage(storeFS.Filesystem(), cache.NewObjectLRUDefault()),
+	r, err := cd.clone(
+		filesystem.NewStorage(storeFS, cache.NewObjectLRUDefault()),
 		workFS.Filesystem(),
-		&git.CloneOptions{
-			URL:      repoURL,
-			Depth:    1,
-			Progress: cd.progress,
-			Auth:     auth,
-		})
+		cloneOptions)
 
 	if err != nil {
+		repo.Close()
 		return nil, fmt.Errorf("error cloning: %s", err)
 	}
 
-	return &Repo{
-		workFS:  workFS,
-		storeFS: storeFS,
-	}, nil
+	hash, err := cd.checkout(r, repo, !cloneOptions.NoCheckout)
+	if err != nil {
+		repo.Close()
+		return nil, err
+	}
+
+	// when several refs are fetched, they are scanned instead of the
+	// worktree, and their own submodules and LFS pointers are looked for
+	if cd.refKinds == 0 {
+		repo.lfsPointers, err = cd.finishWorktree(repoURL, r, hash, storeFS, workFS.Filesystem(), "", nil, cd.submoduleDepth)
+		if err != nil {
+			repo.Close()
+			return nil, err
+		}
+	} else {
+		if cd.refKinds&RefPulls != 0 {
+			err = r.Fetch(&git.FetchOptions{
+				RefSpecs: []config.RefSpec{pullRefSpec},
+				Depth:    cloneOptions.Depth,
+				Auth:     auth,
+				Progress: cd.progress,
+				Tags:     git.NoTags,
+			})
+			if err != nil && err != git.NoErrAlreadyUpToDate {
+				repo.Close()
+				return nil, fmt.Errorf("error fetching pull requests: %s", err)
+			}
+		}
+
+		repo.refs, repo.lfsPointers, err = cd.newRefsFS(repoURL, r, storeFS)
+		if err != nil {
+			repo.Close()
+			return nil, err
+		}
+	}
+
+	for _, storage := range []*Storage{repo.storeFS, repo.workFS} {
+		if storage == nil {
+			continue
+		}
+
+		if err := storage.fitReservation(); err != nil {
+			repo.Close()
+			return nil, err
+		}
+	}
+
+	if cd.mirrorCache != nil {
+		err = cd.mirrorCache.evict()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error evicting mirrors: %s\n", err)
+		}
+	}
+
+	return repo, nil
 }
 
 func (cd *CloneDownloader) SetBlocking(b bool) {
@@ -89,3 +284,356 @@ func (cd *CloneDownloader) SetBlocking(b bool) {
 func (cd *CloneDownloader) SetAuthStorage(s *AuthStorage) {
 	cd.authStorage = s
 }
+
+// SetMemoryBudget makes in-memory storages reserve their memory from b. A
+// nil budget does not limit them.
+func (cd *CloneDownloader) SetMemoryBudget(b *MemoryBudget) {
+	cd.budget = b
+}
+
+func (cd *CloneDownloader) authFor(repoURL string) (transport.AuthMethod, error) {
+	if cd.authStorage == nil {
+		return nil, nil
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	authData := cd.authStorage.GetSiteAuth(u.Host)
+	if authData == nil {
+		return nil, nil
+	}
+
+	return &http.TokenAuth{
+		Token: authData.Value,
+	}, nil
+}
+
+// resolveReference turns the configured reference into a full reference
+// name, asking the remote whether short names are branches or tags.
+func (cd *CloneDownloader) resolveReference(repoURL string, auth transport.AuthMethod) (plumbing.ReferenceName, error) {
+	if strings.HasPrefix(cd.reference, "refs/") {
+		return plumbing.ReferenceName(cd.reference), nil
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{repoURL},
+	})
+
+	remoteRefs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", fmt.Errorf("error listing remote refs: %s", err)
+	}
+
+	candidates := []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(cd.reference),
+		plumbing.NewTagReferenceName(cd.reference),
+	}
+
+	for _, candidate := range candidates {
+		for _, ref := range remoteRefs {
+			if ref.Name() == candidate {
+				return candidate, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("reference %s not found in %s", cd.reference, repoURL)
+}
+
+// clone clones a repository or, when using a mirror cache, fetches into its
+// mirror, cloning it only the first time.
+func (cd *CloneDownloader) clone(storer *filesystem.Storage, workFS billy.Filesystem, options *git.CloneOptions) (*git.Repository, error) {
+	if cd.mirrorCache == nil {
+		return git.Clone(storer, workFS, options)
+	}
+
+	options.Depth = 0
+	options.NoCheckout = true
+	options.SingleBranch = false
+
+	r, err := git.Open(storer, workFS)
+	if err == git.ErrRepositoryNotExists {
+		return git.Clone(storer, workFS, options)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Fetch(&git.FetchOptions{
+		Auth:     options.Auth,
+		Progress: options.Progress,
+		Tags:     options.Tags,
+		Force:    true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, err
+	}
+
+	name := options.ReferenceName
+	if name == "" {
+		name, err = remoteHead(r, options.Auth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, moveHead(r, name)
+}
+
+// remoteHead returns the name of the default branch of the origin remote,
+// or the branch HEAD points to if the remote does not tell.
+func remoteHead(r *git.Repository, auth transport.AuthMethod) (plumbing.ReferenceName, error) {
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return "", err
+	}
+
+	remoteRefs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", err
+	}
+
+	for _, ref := range remoteRefs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target(), nil
+		}
+	}
+
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+
+	return head.Target(), nil
+}
+
+// moveHead points HEAD to a reference, updating branches to the commit
+// last fetched for them.
+func moveHead(r *git.Repository, name plumbing.ReferenceName) error {
+	if name.IsBranch() {
+		remoteRef, err := r.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, name.Short()), true)
+		if err != nil {
+			return err
+		}
+
+		err = r.Storer.SetReference(plumbing.NewHashReference(name, remoteRef.Hash()))
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, name))
+}
+
+// checkout makes sure the worktree holds the configured commit or the
+// cloned HEAD, and returns the hash of the commit checked out. checkedOut
+// tells whether the clone already checked out HEAD.
+func (cd *CloneDownloader) checkout(r *git.Repository, repo *Repo, checkedOut bool) (plumbing.Hash, error) {
+	if cd.commit != "" {
+		hash, err := r.ResolveRevision(plumbing.Revision(cd.commit))
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("error resolving commit %s: %s", cd.commit, err)
+		}
+
+		repo.ref = cd.commit
+
+		return *hash, cd.checkoutHash(r, *hash, repo.Filesystem(), "", nil)
+	}
+
+	head, err := r.Head()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	repo.ref = head.Name().String()
+
+	commit, err := peelCommit(r, head.Hash())
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if checkedOut && !cd.isSparse() {
+		return commit.Hash, nil
+	}
+
+	return commit.Hash, cd.checkoutHash(r, commit.Hash, repo.Filesystem(), "", nil)
+}
+
+// checkoutHash fills the worktree of a clone made with NoCheckout with the
+// tree of a commit. prefix is the path of the worktree inside the top level
+// one, and parent the files of the worktrees holding it, used when filtering
+// files of submodules.
+func (cd *CloneDownloader) checkoutHash(r *git.Repository, hash plumbing.Hash, workFS billy.Filesystem, prefix string, parent *treeFS) error {
+	if cd.isSparse() {
+		return cd.writeWorktree(r, hash, workFS, prefix, parent)
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+
+	err = w.Checkout(&git.CheckoutOptions{
+		Hash:  hash,
+		Force: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error checking out %s: %s", hash, err)
+	}
+
+	return nil
+}
+
+// finishWorktree clones the submodules of a checked out worktree and deals
+// with its LFS pointers, returning them.
+func (cd *CloneDownloader) finishWorktree(repoURL string, r *git.Repository, hash plumbing.Hash, storeFS billy.Filesystem, workFS billy.Filesystem, prefix string, parent *treeFS, depth int) ([]LFSPointer, error) {
+	pointers, err := cd.cloneSubmodules(repoURL, r, hash, storeFS, workFS, prefix, parent, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	if cd.lfsMode == LFSIgnore {
+		return nil, nil
+	}
+
+	ownPointers, err := cd.findLFSPointers(r, hash, workFS)
+	if err != nil {
+		return nil, err
+	}
+
+	if cd.lfsMode == LFSResolve && len(ownPointers) > 0 {
+		err = cd.resolveLFSPointers(repoURL, ownPointers, workFS, func(p LFSPointer) string {
+			return p.Path
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[%s] error resolving LFS pointers: %s\n", repoURL, err)
+		}
+	}
+
+	for i := range ownPointers {
+		ownPointers[i].Path = path.Join(prefix, ownPointers[i].Path)
+	}
+
+	return append(pointers, ownPointers...), nil
+}
+
+func (cd *CloneDownloader) isSparse() bool {
+	return cd.newFileFilter != nil || cd.maxBlobSize > 0
+}
+
+// fileFilter returns the filter of the files of a tree, which can be read
+// from files, combined with the blob size limit.
+func (cd *CloneDownloader) fileFilter(files fs.FS) FileFilter {
+	var filter FileFilter
+	if cd.newFileFilter != nil {
+		filter = cd.newFileFilter(files)
+	}
+
+	return func(path string, size int64) bool {
+		if cd.maxBlobSize > 0 && size > cd.maxBlobSize {
+			return false
+		}
+
+		return filter == nil || filter(path, size)
+	}
+}
+
+// writeWorktree writes the tree of a commit into workFS, skipping the files
+// rejected by the file filter and the blob size limit. go-git does not
+// support partial clone filters, so skipped blobs are still part of the
+// fetched pack, but they never reach the worktree storage.
+func (cd *CloneDownloader) writeWorktree(r *git.Repository, hash plumbing.Hash, workFS billy.Filesystem, prefix string, parent *treeFS) error {
+	tree, err := refTree(r, hash)
+	if err != nil {
+		return err
+	}
+
+	keep := cd.fileFilter(newTreeFS(tree, prefix, parent))
+
+	return tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode == filemode.Symlink || !keep(path.Join(prefix, f.Name), f.Size) {
+			return nil
+		}
+
+		return writeFile(workFS, f)
+	})
+}
+
+func writeFile(workFS billy.Filesystem, f *object.File) error {
+	baseDir := path.Dir(f.Name)
+	if baseDir != "." {
+		err := workFS.MkdirAll(baseDir, os.ModeDir|0755)
+		if err != nil {
+			return err
+		}
+	}
+
+	mode, err := f.Mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	fd, err := workFS.OpenFile(f.Name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	reader, err := f.Reader()
+	if err != nil {
+		return err
+	}
+
+	defer reader.Close()
+
+	_, err = io.Copy(fd, reader)
+	return err
+}
+
+// treeFS is a read-only fs.FS exposing the files of a tree at prefix, and
+// those of the trees holding it, e.g. for a submodule, outside of it.
+type treeFS struct {
+	tree   *object.Tree
+	prefix string
+	parent *treeFS
+}
+
+func newTreeFS(tree *object.Tree, prefix string, parent *treeFS) *treeFS {
+	return &treeFS{
+		tree:   tree,
+		prefix: prefix,
+		parent: parent,
+	}
+}
+
+func (t *treeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if t.prefix != "" && !strings.HasPrefix(name, t.prefix+"/") {
+		if t.parent != nil {
+			return t.parent.Open(name)
+		}
+
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	f, err := t.tree.File(strings.TrimPrefix(name, t.prefix+"/"))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	reader, err := f.Reader()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &refFile{entry: &refEntry{name: path.Base(name), hash: f.Hash, size: f.Size}, ReadCloser: reader}, nil
+}
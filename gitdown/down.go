@@ -28,12 +28,40 @@ type Repo struct {
 	storeFS  *Storage
 	workFS   *Storage
 	releaser func()
+
+	ref         string
+	refs        *RefsFS
+	lfsPointers []LFSPointer
 }
 
 func (r *Repo) Filesystem() billy.Filesystem {
 	return r.workFS.Filesystem()
 }
 
+// Location returns where the contents of the repository were stored, which
+// may be on disk even if memory was asked for.
+func (r *Repo) Location() DownloadLocation {
+	return r.workFS.Location()
+}
+
+// Ref returns the name of the reference, or the commit, checked out in
+// Filesystem. It is empty when the downloader does not know it.
+func (r *Repo) Ref() string {
+	return r.ref
+}
+
+// RefsFS returns the trees of every ref fetched by the downloader, or nil
+// if it was not asked to fetch several refs.
+func (r *Repo) RefsFS() *RefsFS {
+	return r.refs
+}
+
+// LFSPointers returns the Git LFS pointer files found in the repository, if
+// the downloader was asked to look for them.
+func (r *Repo) LFSPointers() []LFSPointer {
+	return r.lfsPointers
+}
+
 func (r *Repo) Close() {
 	if r.storeFS != nil {
 		r.storeFS.Close()
@@ -49,26 +77,85 @@ func (r *Repo) Close() {
 }
 
 type Storage struct {
-	fs       billy.Filesystem
-	location DownloadLocation
-	path     string
+	fs          billy.Filesystem
+	location    DownloadLocation
+	path        string
+	reservation *Reservation
 }
 
 func (s *Storage) Filesystem() billy.Filesystem {
 	return s.fs
 }
 
+func (s *Storage) Location() DownloadLocation {
+	return s.location
+}
+
+// fitReservation resizes the memory reserved by an in-memory storage to the
+// size of the data it holds, once it is known.
+func (s *Storage) fitReservation() error {
+	if s.location != InMemory {
+		return nil
+	}
+
+	size, err := billyDirSize(s.fs, "/")
+	if err != nil {
+		return err
+	}
+
+	s.reservation.Resize(size)
+
+	return nil
+}
+
+func billyDirSize(fs billy.Filesystem, dir string) (int64, error) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			size += entry.Size()
+			continue
+		}
+
+		dirSize, err := billyDirSize(fs, fs.Join(dir, entry.Name()))
+		if err != nil {
+			return 0, err
+		}
+
+		size += dirSize
+	}
+
+	return size, nil
+}
+
 func (s *Storage) Close() {
 	if s.location == InFilesystem && s.path != "" {
 		os.RemoveAll(s.path)
 	}
+
+	s.reservation.Release()
 }
 
-func createStorage(location DownloadLocation) (*Storage, error) {
+// createStorage creates a storage for size bytes of data. In-memory storages
+// reserve them from budget, and are created on disk when it is exhausted.
+func createStorage(location DownloadLocation, budget *MemoryBudget, size int64) (*Storage, error) {
 	var storage billy.Filesystem
+	var reservation *Reservation
 	var tmpPath string
 	var err error
 
+	if location == InMemory {
+		reservation = budget.TryReserve(size)
+		if reservation == nil {
+			location = InFilesystem
+		}
+	}
+
 	switch location {
 	case InFilesystem:
 		tmpPath, err = os.MkdirTemp("/tmp", "gitmon_*")
@@ -86,8 +173,9 @@ func createStorage(location DownloadLocation) (*Storage, error) {
 	}
 
 	return &Storage{
-		fs:       storage,
-		location: location,
-		path:     tmpPath,
+		fs:          storage,
+		location:    location,
+		path:        tmpPath,
+		reservation: reservation,
 	}, nil
 }
@@ -0,0 +1,332 @@
+package gitdown
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// LFSMode tells a downloader what to do with Git LFS pointer files.
+type LFSMode int
+
+const (
+	// LFSIgnore leaves pointer files untouched, so they are grepped as is.
+	LFSIgnore LFSMode = iota
+	// LFSReport leaves pointer files untouched and lists them in
+	// Repo.LFSPointers.
+	LFSReport
+	// LFSResolve replaces pointer files with the objects they point to,
+	// downloaded from the LFS batch API.
+	LFSResolve
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	lfsMaxPointerSize = 1024
+	lfsBatchSize      = 100
+	lfsMediaType      = "application/vnd.git-lfs+json"
+)
+
+// LFSPointer is a Git LFS pointer file found in a repository.
+type LFSPointer struct {
+	// Ref is the ref the pointer file was found in, when the downloader was
+	// asked to fetch several refs, and Path its path inside it.
+	Ref      string
+	Path     string
+	OID      string
+	Size     int64
+	Resolved bool
+}
+
+// lfsObjectPath is where the object oid is kept in the git storage, the way
+// git-lfs does.
+func lfsObjectPath(oid string) string {
+	if len(oid) < 4 {
+		return path.Join("lfs", "objects", oid)
+	}
+
+	return path.Join("lfs", "objects", oid[0:2], oid[2:4], oid)
+}
+
+// findLFSPointers lists the pointer files of the commit hash of r that made
+// it to workFS.
+func (cd *CloneDownloader) findLFSPointers(r *git.Repository, hash plumbing.Hash, workFS billy.Filesystem) ([]LFSPointer, error) {
+	tree, err := refTree(r, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var pointers []LFSPointer
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if f.Mode == filemode.Symlink || f.Size > lfsMaxPointerSize {
+			return nil
+		}
+
+		if _, err := workFS.Stat(f.Name); err != nil {
+			return nil
+		}
+
+		contents, err := f.Contents()
+		if err != nil {
+			return err
+		}
+
+		if pointer, ok := parseLFSPointer(contents); ok {
+			pointer.Path = f.Name
+			pointers = append(pointers, pointer)
+		}
+
+		return nil
+	})
+
+	return pointers, err
+}
+
+func parseLFSPointer(contents string) (LFSPointer, bool) {
+	var pointer LFSPointer
+
+	if !strings.HasPrefix(contents, lfsPointerVersion+"\n") {
+		return pointer, false
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), " ")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "oid":
+			pointer.OID = strings.TrimPrefix(value, "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return pointer, false
+			}
+			pointer.Size = size
+		}
+	}
+
+	return pointer, pointer.OID != "" && pointer.Size > 0
+}
+
+type lfsBatchObject struct {
+	OID     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions struct {
+		Download *struct {
+			Href   string            `json:"href"`
+			Header map[string]string `json:"header"`
+		} `json:"download"`
+	} `json:"actions"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type lfsBatchRequest struct {
+	Operation string           `json:"operation"`
+	Transfers []string         `json:"transfers"`
+	Objects   []lfsBatchObject `json:"objects"`
+}
+
+type lfsBatchResponse struct {
+	Objects []lfsBatchObject `json:"objects"`
+}
+
+// resolveLFSPointers downloads the objects of pointers into fs, at the path
+// pathOf gives for each of them, e.g. replacing the pointer files. Objects
+// bigger than the blob size limit are left as pointers. Failures are
+// reported and the pointer file kept.
+func (cd *CloneDownloader) resolveLFSPointers(repoURL string, pointers []LFSPointer, fs billy.Filesystem, pathOf func(LFSPointer) string) error {
+	byOID := make(map[string][]int)
+
+	var objects []lfsBatchObject
+
+	for i, pointer := range pointers {
+		if cd.maxBlobSize > 0 && pointer.Size > cd.maxBlobSize {
+			continue
+		}
+
+		if _, ok := byOID[pointer.OID]; !ok {
+			objects = append(objects, lfsBatchObject{OID: pointer.OID, Size: pointer.Size})
+		}
+
+		byOID[pointer.OID] = append(byOID[pointer.OID], i)
+	}
+
+	for start := 0; start < len(objects); start += lfsBatchSize {
+		end := start + lfsBatchSize
+		if end > len(objects) {
+			end = len(objects)
+		}
+
+		batch, err := cd.lfsBatch(repoURL, objects[start:end])
+		if err != nil {
+			return err
+		}
+
+		for _, object := range batch {
+			if object.Error != nil || object.Actions.Download == nil {
+				fmt.Fprintf(os.Stderr, "[%s] LFS object %s not available\n", repoURL, object.OID)
+				continue
+			}
+
+			for _, i := range byOID[object.OID] {
+				err = cd.downloadLFSObject(repoURL, object, pathOf(pointers[i]), fs)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "[%s] error downloading LFS object for %s: %s\n", repoURL, pointers[i].Path, err)
+					continue
+				}
+
+				pointers[i].Resolved = true
+			}
+		}
+	}
+
+	return nil
+}
+
+func (cd *CloneDownloader) lfsBatch(repoURL string, objects []lfsBatchObject) ([]lfsBatchObject, error) {
+	body, err := json.Marshal(lfsBatchRequest{
+		Operation: "download",
+		Transfers: []string{"basic"},
+		Objects:   objects,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimSuffix(repoURL, "/")
+	if !strings.HasSuffix(endpoint, ".git") {
+		endpoint += ".git"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/info/lfs/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+
+	err = cd.setRequestAuth(req, repoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("LFS batch request failed: %s", r.Status)
+	}
+
+	var response lfsBatchResponse
+
+	err = json.NewDecoder(r.Body).Decode(&response)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding LFS batch response: %s", err)
+	}
+
+	return response.Objects, nil
+}
+
+// downloadLFSObject downloads object into the file at name of fs. The
+// object is written to a temporary file first, which only replaces the file
+// once its size and checksum are verified.
+func (cd *CloneDownloader) downloadLFSObject(repoURL string, object lfsBatchObject, name string, fs billy.Filesystem) error {
+	req, err := http.NewRequest(http.MethodGet, object.Actions.Download.Href, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(object.Actions.Download.Header) > 0 {
+		for name, value := range object.Actions.Download.Header {
+			req.Header.Set(name, value)
+		}
+	} else {
+		err = cd.setRequestAuth(req, repoURL)
+		if err != nil {
+			return err
+		}
+	}
+
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s", r.Status)
+	}
+
+	fd, err := fs.TempFile(path.Dir(name), ".lfs-download-")
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+
+	n, err := io.Copy(io.MultiWriter(fd, hash), r.Body)
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil && n != object.Size {
+		err = fmt.Errorf("size mismatch, got %d bytes", n)
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); err == nil && sum != object.OID {
+		err = fmt.Errorf("checksum mismatch, got %s", sum)
+	}
+
+	if err == nil {
+		err = fs.Rename(fd.Name(), name)
+	}
+
+	if err != nil {
+		fs.Remove(fd.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (cd *CloneDownloader) setRequestAuth(req *http.Request, repoURL string) error {
+	auth, err := cd.authFor(repoURL)
+	if err != nil {
+		return err
+	}
+
+	if httpAuth, ok := auth.(githttp.AuthMethod); ok {
+		httpAuth.SetAuth(req)
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package gitdown
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+func TestParseLFSPointer(t *testing.T) {
+	tests := []struct {
+		contents string
+		pointer  LFSPointer
+		ok       bool
+	}{
+		{
+			contents: "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\nsize 12345\n",
+			pointer:  LFSPointer{OID: "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393", Size: 12345},
+			ok:       true,
+		},
+		{
+			contents: "version https://git-lfs.github.com/spec/v1\noid sha256:4d7a\nsize big\n",
+			ok:       false,
+		},
+		{
+			contents: "oid sha256:4d7a\nsize 1\n",
+			ok:       false,
+		},
+	}
+
+	for _, test := range tests {
+		pointer, ok := parseLFSPointer(test.contents)
+		if ok != test.ok || (ok && pointer != test.pointer) {
+			t.Errorf("parseLFSPointer(%q) = %+v, %v, want %+v, %v", test.contents, pointer, ok, test.pointer, test.ok)
+		}
+	}
+}
+
+func TestDownloadLFSObject(t *testing.T) {
+	const (
+		pointer = "version https://git-lfs.github.com/spec/v1\n"
+		content = "the object content"
+	)
+
+	sum := sha256.Sum256([]byte(content))
+	oid := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		size   int64
+		want   string
+		err    bool
+	}{
+		{name: "ok", status: http.StatusOK, body: content, size: int64(len(content)), want: content},
+		{name: "not found", status: http.StatusNotFound, body: content, size: int64(len(content)), want: pointer, err: true},
+		{name: "truncated", status: http.StatusOK, body: content[:5], size: int64(len(content)), want: pointer, err: true},
+		{name: "corrupt", status: http.StatusOK, body: "the object c0ntent", size: int64(len(content)), want: pointer, err: true},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		fs := memfs.New()
+		if err := util.WriteFile(fs, "data/model.bin", []byte(pointer), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		object := lfsBatchObject{OID: oid, Size: test.size}
+		object.Actions.Download = &struct {
+			Href   string            `json:"href"`
+			Header map[string]string `json:"header"`
+		}{Href: server.URL, Header: map[string]string{"X-Test": "1"}}
+
+		cd := &CloneDownloader{}
+		err := cd.downloadLFSObject(server.URL, object, "data/model.bin", fs)
+		server.Close()
+
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+
+		got, err := util.ReadFile(fs, "data/model.bin")
+		if err != nil || string(got) != test.want {
+			t.Errorf("%s: file holds %q, %v, want %q", test.name, got, err, test.want)
+		}
+
+		entries, _ := fs.ReadDir("data")
+		if len(entries) != 1 {
+			t.Errorf("%s: %d files left in data, want 1", test.name, len(entries))
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package gitdown
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MirrorCache keeps on-disk mirrors of cloned repositories, keyed by URL, so
+// downloading a repository again only fetches what changed. Once the mirrors
+// take more than the disk budget, the least recently used ones are evicted.
+// Mirrors are shared by every downloader of the process using the cache,
+// but the cache does not coordinate with other processes.
+type MirrorCache struct {
+	dir    string
+	budget int64
+
+	lock     sync.Mutex
+	inUse    map[string]int
+	urlLocks map[string]*sync.Mutex
+}
+
+type mirror struct {
+	path    string
+	unlock  func()
+	release func()
+}
+
+// NewMirrorCache creates a cache storing mirrors in dir, using up to budget
+// bytes of disk. A budget of zero disables eviction.
+func NewMirrorCache(dir string, budget int64) (*MirrorCache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MirrorCache{
+		dir:      dir,
+		budget:   budget,
+		inUse:    make(map[string]int),
+		urlLocks: make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// acquire returns the mirror of repoURL, locked against concurrent updates
+// until unlock is called, and protected from eviction until release is.
+func (c *MirrorCache) acquire(repoURL string) (*mirror, error) {
+	sum := sha256.Sum256([]byte(repoURL))
+	key := hex.EncodeToString(sum[:])
+
+	c.lock.Lock()
+	urlLock, ok := c.urlLocks[key]
+	if !ok {
+		urlLock = &sync.Mutex{}
+		c.urlLocks[key] = urlLock
+	}
+	c.inUse[key]++
+	c.lock.Unlock()
+
+	urlLock.Lock()
+
+	path := filepath.Join(c.dir, key)
+
+	err := os.MkdirAll(path, 0755)
+	if err == nil {
+		now := time.Now()
+		err = os.Chtimes(path, now, now)
+	}
+
+	var once sync.Once
+
+	m := &mirror{
+		path:   path,
+		unlock: func() { once.Do(urlLock.Unlock) },
+		release: func() {
+			c.lock.Lock()
+			c.inUse[key]--
+			c.lock.Unlock()
+		},
+	}
+
+	if err != nil {
+		m.unlock()
+		m.release()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// evict removes the least recently used mirrors not in use until the cache
+// fits in its budget.
+func (c *MirrorCache) evict() error {
+	if c.budget <= 0 {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type cached struct {
+		key      string
+		size     int64
+		lastUsed time.Time
+	}
+
+	var mirrors []cached
+	var total int64
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		size, err := dirSize(filepath.Join(c.dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		mirrors = append(mirrors, cached{key: entry.Name(), size: size, lastUsed: info.ModTime()})
+		total += size
+	}
+
+	sort.Slice(mirrors, func(i, j int) bool {
+		return mirrors[i].lastUsed.Before(mirrors[j].lastUsed)
+	})
+
+	for _, m := range mirrors {
+		if total <= c.budget {
+			break
+		}
+
+		if c.inUse[m.key] > 0 {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(c.dir, m.key))
+		if err != nil {
+			return fmt.Errorf("error evicting mirror %s: %s", m.key, err)
+		}
+
+		total -= m.size
+	}
+
+	return nil
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
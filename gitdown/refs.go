@@ -0,0 +1,528 @@
+package gitdown
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// RefKind selects which groups of references a clone fetches and exposes
+// for scanning. Values can be combined.
+type RefKind int
+
+const (
+	RefBranches RefKind = 1 << iota
+	RefTags
+	RefPulls
+)
+
+const pullRefSpec = "+refs/pull/*/head:refs/remotes/origin/pull/*"
+
+// RefLocation is a place, inside a given ref, where a blob can be found.
+type RefLocation struct {
+	Ref  string
+	Path string
+}
+
+// RefsFS is a read-only fs.FS exposing the trees of several refs of a
+// repository. Every ref lives under a directory named after it, e.g.
+// refs/heads/main/README.md, and submodules under their path inside it.
+// Blobs shared between refs only appear once, at the first location they
+// were found in; Locations returns every place a file in this filesystem can
+// be found in.
+type RefsFS struct {
+	refs      map[string]bool
+	files     map[string]*refEntry
+	dirs      map[string]map[string]*refEntry
+	locations map[string][]RefLocation
+	// objects holds the LFS objects resolved pointer files are replaced with
+	objects billy.Filesystem
+}
+
+// refsBuilder adds the trees of refs, and of their submodules, to a RefsFS.
+type refsBuilder struct {
+	cd      *CloneDownloader
+	rfs     *RefsFS
+	storeFS billy.Filesystem
+	seen    map[plumbing.Hash]string
+	// pointers are the LFS pointer files added, by the repository they
+	// belong to
+	pointers map[string][]refPointer
+}
+
+// refPointer is an LFS pointer file of RefsFS.
+type refPointer struct {
+	name    string
+	pointer LFSPointer
+}
+
+// newRefsFS exposes the refs of r of the kinds the downloader was asked
+// for, along with their submodules, which are stored under the modules
+// directory of storeFS. It returns the LFS pointer files found in them,
+// resolving them into storeFS if asked to.
+func (cd *CloneDownloader) newRefsFS(repoURL string, r *git.Repository, storeFS billy.Filesystem) (*RefsFS, []LFSPointer, error) {
+	refs, err := collectRefs(r, cd.refKinds)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := &refsBuilder{
+		cd: cd,
+		rfs: &RefsFS{
+			refs:      make(map[string]bool),
+			files:     make(map[string]*refEntry),
+			dirs:      map[string]map[string]*refEntry{".": {}},
+			locations: make(map[string][]RefLocation),
+			objects:   storeFS,
+		},
+		storeFS:  storeFS,
+		seen:     make(map[plumbing.Hash]string),
+		pointers: make(map[string][]refPointer),
+	}
+
+	for _, ref := range refs {
+		b.rfs.refs[ref.name] = true
+
+		err := b.addTree(repoURL, r, ref.hash, ref.name, "", nil, cd.submoduleDepth)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error resolving tree for %s: %s", ref.name, err)
+		}
+	}
+
+	if cd.lfsMode == LFSResolve {
+		b.resolvePointers()
+	}
+
+	var pointers []LFSPointer
+
+	for _, repoPointers := range b.pointers {
+		for _, p := range repoPointers {
+			for _, location := range b.rfs.locations[p.name] {
+				pointer := p.pointer
+				pointer.Ref = location.Ref
+				pointer.Path = location.Path
+				pointers = append(pointers, pointer)
+			}
+		}
+	}
+
+	sort.Slice(pointers, func(i, j int) bool {
+		if pointers[i].Ref != pointers[j].Ref {
+			return pointers[i].Ref < pointers[j].Ref
+		}
+
+		return pointers[i].Path < pointers[j].Path
+	})
+
+	return b.rfs, pointers, nil
+}
+
+// addTree adds the tree of the commit hash of r, the repository at repoURL,
+// to the ref called ref, at prefix inside the trees whose files are parent.
+// Submodules are added up to depth levels deep.
+func (b *refsBuilder) addTree(repoURL string, r *git.Repository, hash plumbing.Hash, ref string, prefix string, parent *treeFS, depth int) error {
+	tree, err := refTree(r, hash)
+	if err != nil {
+		return err
+	}
+
+	files := newTreeFS(tree, prefix, parent)
+	keep := b.cd.fileFilter(files)
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		filePath := path.Join(prefix, f.Name)
+
+		if f.Mode == filemode.Symlink || !keep(filePath, f.Size) {
+			return nil
+		}
+
+		location := RefLocation{Ref: ref, Path: filePath}
+
+		if first, ok := b.seen[f.Hash]; ok {
+			b.rfs.locations[first] = append(b.rfs.locations[first], location)
+			return nil
+		}
+
+		name := path.Join(ref, filePath)
+		b.seen[f.Hash] = name
+
+		b.rfs.addFile(name, &refEntry{repo: r, hash: f.Hash, size: f.Size})
+		b.rfs.locations[name] = []RefLocation{location}
+
+		if b.cd.lfsMode == LFSIgnore || f.Size > lfsMaxPointerSize {
+			return nil
+		}
+
+		contents, err := f.Contents()
+		if err != nil {
+			return err
+		}
+
+		if pointer, ok := parseLFSPointer(contents); ok {
+			b.pointers[repoURL] = append(b.pointers[repoURL], refPointer{name: name, pointer: pointer})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if depth <= 0 {
+		return nil
+	}
+
+	submodules, err := submodulesOf(repoURL, tree)
+	if err != nil {
+		return err
+	}
+
+	for _, submodule := range submodules {
+		err := b.addSubmodule(submodule, ref, path.Join(prefix, submodule.path), files, depth)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error cloning submodule %s: %s\n", submodule.name, err)
+		}
+	}
+
+	return nil
+}
+
+// addSubmodule adds the tree of a submodule to the ref called ref, at
+// prefix inside the trees whose files are parent. Submodules are kept bare,
+// as they are only read through RefsFS.
+func (b *refsBuilder) addSubmodule(submodule submoduleCommit, ref string, prefix string, parent *treeFS, depth int) error {
+	auth, err := b.cd.authFor(submodule.url)
+	if err != nil {
+		return err
+	}
+
+	subStoreFS, err := b.storeFS.Chroot(b.storeFS.Join("modules", submodule.name))
+	if err != nil {
+		return err
+	}
+
+	r, err := b.cd.openSubmodule(submodule.url, submodule.hash, subStoreFS, nil, auth)
+	if err != nil {
+		return err
+	}
+
+	return b.addTree(submodule.url, r, submodule.hash, ref, prefix, parent, depth-1)
+}
+
+// resolvePointers downloads the objects of the LFS pointer files added into
+// the lfs directory of the git storage, and has RefsFS expose them instead of
+// the pointer files. Objects already downloaded, e.g. into a mirror, are not
+// downloaded again.
+func (b *refsBuilder) resolvePointers() {
+	for repoURL, repoPointers := range b.pointers {
+		// missing are the pointers whose objects are not downloaded yet,
+		// found at indexes of repoPointers
+		var missing []LFSPointer
+		var indexes []int
+
+		for i := range repoPointers {
+			pointer := &repoPointers[i].pointer
+
+			info, err := b.storeFS.Stat(lfsObjectPath(pointer.OID))
+			if err == nil && info.Size() == pointer.Size {
+				pointer.Resolved = true
+				continue
+			}
+
+			missing = append(missing, *pointer)
+			indexes = append(indexes, i)
+		}
+
+		if len(missing) > 0 {
+			err := b.cd.resolveLFSPointers(repoURL, missing, b.storeFS, func(p LFSPointer) string {
+				return lfsObjectPath(p.OID)
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "[%s] error resolving LFS pointers: %s\n", repoURL, err)
+			}
+		}
+
+		for j, pointer := range missing {
+			repoPointers[indexes[j]].pointer.Resolved = pointer.Resolved
+		}
+
+		for _, p := range repoPointers {
+			if p.pointer.Resolved {
+				entry := b.rfs.files[p.name]
+				entry.oid = p.pointer.OID
+				entry.size = p.pointer.Size
+			}
+		}
+	}
+}
+
+// TreeRoot returns the ref the file at name is exposed under, so that paths
+// can be told relative to the ref.
+func (rfs *RefsFS) TreeRoot(name string) string {
+	name = strings.TrimPrefix(name, "/")
+
+	if locations, ok := rfs.locations[name]; ok {
+		return locations[0].Ref
+	}
+
+	// files inside files of the filesystem, like archive members, are not
+	// known to it
+	for dir := path.Dir(name); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if rfs.refs[dir] {
+			return dir
+		}
+	}
+
+	return ""
+}
+
+// Locations returns every ref and path a file of this filesystem was found
+// in, including the one it is exposed under.
+func (rfs *RefsFS) Locations(name string) []RefLocation {
+	return rfs.locations[strings.TrimPrefix(name, "/")]
+}
+
+func (rfs *RefsFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if entry, ok := rfs.files[name]; ok {
+		if entry.oid != "" {
+			object, err := rfs.objects.Open(lfsObjectPath(entry.oid))
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+
+			return &refFile{entry: entry, ReadCloser: object}, nil
+		}
+
+		blob, err := entry.repo.BlobObject(entry.hash)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+
+		reader, err := blob.Reader()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+
+		return &refFile{entry: entry, ReadCloser: reader}, nil
+	}
+
+	if _, ok := rfs.dirs[name]; ok {
+		entries, _ := rfs.ReadDir(name)
+		return &refDir{entry: &refEntry{name: path.Base(name), dir: true}, entries: entries}, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (rfs *RefsFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	children, ok := rfs.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, child)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+func (rfs *RefsFS) addFile(name string, entry *refEntry) {
+	entry.name = path.Base(name)
+	rfs.files[name] = entry
+
+	for {
+		parent := path.Dir(name)
+
+		children, ok := rfs.dirs[parent]
+		if !ok {
+			children = make(map[string]*refEntry)
+			rfs.dirs[parent] = children
+		}
+
+		children[entry.name] = entry
+
+		if parent == "." || ok {
+			return
+		}
+
+		name = parent
+		entry = &refEntry{name: path.Base(name), dir: true}
+	}
+}
+
+type namedRef struct {
+	name string
+	hash plumbing.Hash
+}
+
+// collectRefs lists the refs of a freshly cloned repository that match
+// kinds, named the way they are named on the remote.
+func collectRefs(r *git.Repository, kinds RefKind) ([]namedRef, error) {
+	iter, err := r.References()
+	if err != nil {
+		return nil, err
+	}
+
+	const remotePrefix = "refs/remotes/origin/"
+
+	var refs []namedRef
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		name := ref.Name().String()
+
+		switch {
+		case strings.HasPrefix(name, remotePrefix+"pull/"):
+			if kinds&RefPulls == 0 {
+				return nil
+			}
+			name = "refs/pull/" + strings.TrimPrefix(name, remotePrefix+"pull/") + "/head"
+
+		case strings.HasPrefix(name, remotePrefix):
+			if kinds&RefBranches == 0 {
+				return nil
+			}
+			name = "refs/heads/" + strings.TrimPrefix(name, remotePrefix)
+
+		case ref.Name().IsTag():
+			if kinds&RefTags == 0 {
+				return nil
+			}
+
+		default:
+			return nil
+		}
+
+		refs = append(refs, namedRef{name: name, hash: ref.Hash()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].name < refs[j].name
+	})
+
+	return refs, nil
+}
+
+// peelCommit returns the commit pointed by hash, peeling annotated tags.
+func peelCommit(r *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	if tag, err := r.TagObject(hash); err == nil {
+		return tag.Commit()
+	}
+
+	return r.CommitObject(hash)
+}
+
+// refTree returns the tree pointed by a commit, peeling annotated tags.
+func refTree(r *git.Repository, hash plumbing.Hash) (*object.Tree, error) {
+	commit, err := peelCommit(r, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return commit.Tree()
+}
+
+// refEntry is both the fs.FileInfo and the fs.DirEntry of RefsFS nodes.
+type refEntry struct {
+	name string
+	// repo is the repository holding the blob hash, which may be a
+	// submodule of the one the refs belong to.
+	repo *git.Repository
+	hash plumbing.Hash
+	// oid is the LFS object the file is read from, for resolved pointers.
+	oid  string
+	size int64
+	dir  bool
+}
+
+func (e *refEntry) Name() string               { return e.name }
+func (e *refEntry) Size() int64                { return e.size }
+func (e *refEntry) ModTime() time.Time         { return time.Time{} }
+func (e *refEntry) IsDir() bool                { return e.dir }
+func (e *refEntry) Sys() interface{}           { return nil }
+func (e *refEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e *refEntry) Info() (fs.FileInfo, error) { return e, nil }
+
+func (e *refEntry) Mode() fs.FileMode {
+	if e.dir {
+		return fs.ModeDir | 0555
+	}
+
+	return 0444
+}
+
+type refFile struct {
+	entry *refEntry
+	io.ReadCloser
+}
+
+func (f *refFile) Stat() (fs.FileInfo, error) {
+	return f.entry, nil
+}
+
+type refDir struct {
+	entry   *refEntry
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *refDir) Stat() (fs.FileInfo, error) {
+	return d.entry, nil
+}
+
+func (d *refDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: fs.ErrInvalid}
+}
+
+func (d *refDir) Close() error {
+	return nil
+}
+
+func (d *refDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+
+	d.offset += n
+
+	return remaining[:n], nil
+}
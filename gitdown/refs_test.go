@@ -0,0 +1,37 @@
+package gitdown
+
+import "testing"
+
+func TestRefsFSTreeRoot(t *testing.T) {
+	rfs := &RefsFS{
+		refs: map[string]bool{
+			"refs/heads/main":   true,
+			"refs/heads/feat/x": true,
+			"refs/tags/v1.0.0":  true,
+		},
+		locations: map[string][]RefLocation{
+			"refs/heads/main/a.go": {
+				{Ref: "refs/heads/main", Path: "a.go"},
+				{Ref: "refs/heads/feat/x", Path: "a.go"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		root string
+	}{
+		{"refs/heads/main/a.go", "refs/heads/main"},
+		{"/refs/heads/main/a.go", "refs/heads/main"},
+		{"refs/heads/feat/x/lib/b.go", "refs/heads/feat/x"},
+		{"refs/tags/v1.0.0/app.jar/META-INF/c.xml", "refs/tags/v1.0.0"},
+		{"refs/heads/gone/a.go", ""},
+		{"a.go", ""},
+	}
+
+	for _, test := range tests {
+		if root := rfs.TreeRoot(test.name); root != test.root {
+			t.Errorf("TreeRoot(%q) = %q, want %q", test.name, root, test.root)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package gitdown
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// submoduleCommit is a submodule of a tree, and the commit the tree records
+// for it.
+type submoduleCommit struct {
+	name string
+	path string
+	url  string
+	hash plumbing.Hash
+}
+
+// submodulesOf lists the submodules of tree, from the .gitmodules file of
+// the repository at repoURL. Broken submodules are reported and skipped.
+func submodulesOf(repoURL string, tree *object.Tree) ([]submoduleCommit, error) {
+	modulesFile, err := tree.File(".gitmodules")
+	if err != nil {
+		return nil, nil
+	}
+
+	contents, err := modulesFile.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	modules := config.NewModules()
+	err = modules.Unmarshal([]byte(contents))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing .gitmodules: %s", err)
+	}
+
+	var submodules []submoduleCommit
+
+	for _, submodule := range modules.Submodules {
+		entry, err := tree.FindEntry(submodule.Path)
+		if err != nil || entry.Mode != filemode.Submodule {
+			fmt.Fprintf(os.Stderr, "submodule %s has no commit in the tree, skipping\n", submodule.Name)
+			continue
+		}
+
+		subURL, err := resolveSubmoduleURL(repoURL, submodule.URL)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "submodule %s has an invalid url: %s\n", submodule.Name, err)
+			continue
+		}
+
+		submodules = append(submodules, submoduleCommit{
+			name: submodule.Name,
+			path: submodule.Path,
+			url:  subURL,
+			hash: entry.Hash,
+		})
+	}
+
+	return submodules, nil
+}
+
+// cloneSubmodules clones the submodules of the commit hash of r into workFS,
+// storing their git data under the modules directory of storeFS, the way
+// git does. Submodules are fetched with the auth of their own host and
+// checked out at the commit recorded by the parent tree. Broken submodules
+// are reported and skipped.
+func (cd *CloneDownloader) cloneSubmodules(repoURL string, r *git.Repository, hash plumbing.Hash, storeFS billy.Filesystem, workFS billy.Filesystem, prefix string, parent *treeFS, depth int) ([]LFSPointer, error) {
+	if depth <= 0 {
+		return nil, nil
+	}
+
+	tree, err := refTree(r, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	submodules, err := submodulesOf(repoURL, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	files := newTreeFS(tree, prefix, parent)
+
+	var pointers []LFSPointer
+
+	for _, submodule := range submodules {
+		subPointers, err := cd.cloneSubmodule(
+			submodule.url,
+			submodule.hash,
+			storeFS.Join("modules", submodule.name),
+			storeFS,
+			workFS,
+			submodule.path,
+			path.Join(prefix, submodule.path),
+			files,
+			depth,
+		)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error cloning submodule %s: %s\n", submodule.name, err)
+			continue
+		}
+
+		pointers = append(pointers, subPointers...)
+	}
+
+	return pointers, nil
+}
+
+func (cd *CloneDownloader) cloneSubmodule(subURL string, hash plumbing.Hash, storePath string, storeFS billy.Filesystem, workFS billy.Filesystem, workPath string, prefix string, parent *treeFS, depth int) ([]LFSPointer, error) {
+	auth, err := cd.authFor(subURL)
+	if err != nil {
+		return nil, err
+	}
+
+	subStoreFS, err := storeFS.Chroot(storePath)
+	if err != nil {
+		return nil, err
+	}
+
+	subWorkFS, err := workFS.Chroot(workPath)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := cd.openSubmodule(subURL, hash, subStoreFS, subWorkFS, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cd.checkoutHash(r, hash, subWorkFS, prefix, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	return cd.finishWorktree(subURL, r, hash, subStoreFS, subWorkFS, prefix, parent, depth-1)
+}
+
+// openSubmodule returns the repository of a submodule stored in storeFS,
+// holding the commit hash. Submodules already stored, e.g. in a mirror kept
+// from a previous download, are fetched from only when they miss the commit;
+// the rest are cloned.
+func (cd *CloneDownloader) openSubmodule(subURL string, hash plumbing.Hash, storeFS billy.Filesystem, workFS billy.Filesystem, auth transport.AuthMethod) (*git.Repository, error) {
+	storer := filesystem.NewStorage(storeFS, cache.NewObjectLRUDefault())
+
+	r, err := git.Open(storer, workFS)
+	if err == git.ErrRepositoryNotExists {
+		return git.Clone(storer, workFS, &git.CloneOptions{
+			URL:        subURL,
+			Progress:   cd.progress,
+			Auth:       auth,
+			NoCheckout: true,
+			Tags:       git.NoTags,
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.CommitObject(hash); err == nil {
+		return r, nil
+	}
+
+	err = r.Fetch(&git.FetchOptions{
+		Auth:     auth,
+		Progress: cd.progress,
+		Tags:     git.NoTags,
+		Force:    true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// resolveSubmoduleURL resolves submodule URLs relative to the parent
+// repository, e.g. ../other.git.
+func resolveSubmoduleURL(repoURL string, subURL string) (string, error) {
+	if !strings.HasPrefix(subURL, "./") && !strings.HasPrefix(subURL, "../") {
+		return subURL, nil
+	}
+
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path = path.Join(u.Path, subURL)
+
+	return u.String(), nil
+}
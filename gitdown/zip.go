@@ -13,7 +13,6 @@ import (
 	"path"
 	"runtime"
 	"strings"
-	"syscall"
 	"time"
 )
 
@@ -22,23 +21,25 @@ type ZipDownloader struct {
 
 	blocking    bool
 	authStorage *AuthStorage
+	budget      *MemoryBudget
 }
 
+// NewZipDownloader creates a downloader extracting zips into storageLocation.
+// InMemory is a preference: downloads that do not fit in the memory budget
+// are extracted to disk.
 func NewZipDownloader(storageLocation DownloadLocation) *ZipDownloader {
 	return &ZipDownloader{
 		storageLocation: storageLocation,
+		budget:          DefaultMemoryBudget,
 	}
 }
 
 func (d *ZipDownloader) Download(repoURL string) (*Repo, error) {
-	info := syscall.Sysinfo_t{}
-	syscall.Sysinfo(&info)
-	maxRamCore := (info.Totalram / 10 * 7) / uint64(runtime.NumCPU())
+	zipFile, reservation, releaser, err := d.downloadZip(repoURL)
 
-	maxZipFileSize := maxRamCore / 5
-	maxZipUncompressedSize := maxZipFileSize * 4
+	// the in-memory zip is only needed while extracting it
+	defer reservation.Release()
 
-	zipFile, releaser, err := d.downloadZip(repoURL, maxZipFileSize)
 	if err != nil {
 		if releaser != nil {
 			releaser()
@@ -52,13 +53,7 @@ func (d *ZipDownloader) Download(repoURL string) (*Repo, error) {
 		size += entry.UncompressedSize64
 	}
 
-	if size > maxZipUncompressedSize {
-		d.storageLocation = InFilesystem
-	} else {
-		d.storageLocation = InMemory
-	}
-
-	storage, err := createStorage(d.storageLocation)
+	storage, err := createStorage(d.storageLocation, d.budget, int64(size))
 	if err != nil {
 		if releaser != nil {
 			releaser()
@@ -128,17 +123,59 @@ func (cd *ZipDownloader) SetAuthStorage(s *AuthStorage) {
 	cd.authStorage = s
 }
 
-func (d *ZipDownloader) downloadZip(zipURL string, maxInMemory uint64) (*zip.Reader, func(), error) {
+// SetMemoryBudget makes in-memory downloads reserve their memory from b. A
+// nil budget does not limit them.
+func (cd *ZipDownloader) SetMemoryBudget(b *MemoryBudget) {
+	cd.budget = b
+}
+
+// zipBufferSize is how much memory to reserve for a zip whose size is not
+// known in advance, a share of the budget for every core. Without a budget,
+// the share is taken from the system memory.
+func (d *ZipDownloader) zipBufferSize() int64 {
+	limit := d.budget.Limit()
+	if d.budget == nil {
+		limit = systemMemoryLimit()
+	}
+
+	return limit / int64(runtime.NumCPU()) / 5
+}
+
+// zipBufferFor is how much memory to reserve for a zip of contentLength
+// bytes, as told by the server. The size is never trusted past the budget
+// limit, or past zipBufferSize without a budget: bigger zips are read up to
+// there and dumped to disk.
+func (d *ZipDownloader) zipBufferFor(contentLength int64) int64 {
+	if contentLength <= 0 {
+		return d.zipBufferSize()
+	}
+
+	limit := d.budget.Limit()
+	if d.budget == nil {
+		limit = d.zipBufferSize()
+	}
+
+	if contentLength > limit {
+		return limit
+	}
+
+	return contentLength
+}
+
+// downloadZip downloads a zip, keeping it in memory if it fits in the budget
+// and dumping it to disk otherwise. The returned reservation holds the memory
+// taken by an in-memory zip, and the releaser removes an on-disk one.
+func (d *ZipDownloader) downloadZip(zipURL string) (*zip.Reader, *Reservation, func(), error) {
 	for {
 		req, err := http.NewRequest(http.MethodGet, zipURL, nil)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
 		if d.authStorage != nil {
 			u, err := url.Parse(zipURL)
 			if err != nil {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 
 			authData := d.authStorage.GetSiteAuth(u.Host)
@@ -149,7 +186,7 @@ func (d *ZipDownloader) downloadZip(zipURL string, maxInMemory uint64) (*zip.Rea
 
 		r, err := http.DefaultClient.Do(req)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 
 		defer r.Body.Close()
@@ -162,27 +199,46 @@ func (d *ZipDownloader) downloadZip(zipURL string, maxInMemory uint64) (*zip.Rea
 			}
 		}
 
-		safeBuffer := make([]byte, maxInMemory)
+		bufferSize := d.zipBufferFor(r.ContentLength)
+
+		// read one byte more than expected to tell whether the zip fits
+		reservation := d.budget.TryReserve(bufferSize + 1)
+
+		var firstChunkReader *bytes.Reader
 
-		nread, err := io.ReadFull(r.Body, safeBuffer)
+		if reservation != nil {
+			safeBuffer := make([]byte, bufferSize+1)
 
-		firstChunkReader := bytes.NewReader(safeBuffer)
+			nread, err := io.ReadFull(r.Body, safeBuffer)
 
-		if err == io.ErrUnexpectedEOF {
-			zipReader, err := zip.NewReader(firstChunkReader, int64(nread))
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				reservation.Resize(int64(nread))
+
+				zipReader, err := zip.NewReader(bytes.NewReader(safeBuffer[:nread]), int64(nread))
+				if err != nil {
+					reservation.Release()
+					return nil, nil, nil, err
+				}
+
+				return zipReader, reservation, nil, nil
+			}
 
 			if err != nil {
-				return nil, nil, err
+				reservation.Release()
+				return nil, nil, nil, err
 			}
 
-			return zipReader, nil, nil
+			firstChunkReader = bytes.NewReader(safeBuffer)
+		} else {
+			firstChunkReader = bytes.NewReader(nil)
 		}
 
 		log.Printf("[%s] Zip does not fit in memory, dumping to disk\n", zipURL)
 
 		fd, err := ioutil.TempFile("/tmp", "gitmon_zip_")
 		if err != nil {
-			return nil, nil, err
+			reservation.Release()
+			return nil, nil, nil, err
 		}
 
 		releaser := func() {
@@ -193,29 +249,31 @@ func (d *ZipDownloader) downloadZip(zipURL string, maxInMemory uint64) (*zip.Rea
 		totalSize := int64(0)
 
 		copied, err := io.Copy(fd, firstChunkReader)
+		reservation.Release()
+
 		if err != nil {
-			return nil, releaser, err
+			return nil, nil, releaser, err
 		}
 
 		totalSize += copied
 
 		copied, err = io.Copy(fd, r.Body)
 		if err != nil {
-			return nil, releaser, err
+			return nil, nil, releaser, err
 		}
 
 		totalSize += copied
 
 		newPos, err := fd.Seek(0, 0)
 		if err != nil || newPos != 0 {
-			return nil, releaser, fmt.Errorf("error seeking, pos = %d: %s", newPos, err)
+			return nil, nil, releaser, fmt.Errorf("error seeking, pos = %d: %s", newPos, err)
 		}
 
 		zipReader, err := zip.NewReader(fd, totalSize)
 		if err != nil {
-			return nil, releaser, err
+			return nil, nil, releaser, err
 		}
 
-		return zipReader, releaser, nil
+		return zipReader, nil, releaser, nil
 	}
 }
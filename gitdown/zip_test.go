@@ -0,0 +1,32 @@
+package gitdown
+
+import (
+	"math"
+	"testing"
+)
+
+func TestZipBufferFor(t *testing.T) {
+	unsized := (&ZipDownloader{}).zipBufferSize()
+
+	tests := []struct {
+		name          string
+		budget        *MemoryBudget
+		contentLength int64
+		want          int64
+	}{
+		{name: "unknown size", budget: nil, contentLength: -1, want: unsized},
+		{name: "small zip without budget", budget: nil, contentLength: 10, want: 10},
+		{name: "huge zip without budget", budget: nil, contentLength: math.MaxInt64, want: unsized},
+		{name: "small zip", budget: NewMemoryBudget(1000), contentLength: 10, want: 10},
+		{name: "zip past the budget", budget: NewMemoryBudget(1000), contentLength: math.MaxInt64, want: 1000},
+		{name: "unknown size with budget", budget: NewMemoryBudget(5000), contentLength: 0, want: (&ZipDownloader{budget: NewMemoryBudget(5000)}).zipBufferSize()},
+	}
+
+	for _, test := range tests {
+		d := &ZipDownloader{budget: test.budget}
+
+		if got := d.zipBufferFor(test.contentLength); got != test.want {
+			t.Errorf("%s: zipBufferFor(%d) = %d, want %d", test.name, test.contentLength, got, test.want)
+		}
+	}
+}
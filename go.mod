@@ -7,6 +7,7 @@ require (
 	github.com/go-git/go-billy/v5 v5.3.1
 	github.com/go-git/go-git/v5 v5.4.2
 	github.com/pkg/profile v1.6.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
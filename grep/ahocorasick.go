@@ -0,0 +1,170 @@
+package grep
+
+// ahoCorasick finds every occurrence of a set of literals in a single pass
+// over the data, whatever the number of literals.
+type ahoCorasick struct {
+	// next is the state reached from every state with every byte, failure
+	// links already followed.
+	next [][256]int32
+	// output are the literals ending at every state.
+	output  [][]int
+	lengths []int
+	// fold matches regardless of ASCII case.
+	fold bool
+}
+
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+
+	return b
+}
+
+func newAhoCorasick(literals []string, fold bool) *ahoCorasick {
+	ac := &ahoCorasick{
+		next:    make([][256]int32, 1),
+		output:  make([][]int, 1),
+		lengths: make([]int, len(literals)),
+		fold:    fold,
+	}
+
+	// trie of the literals, 0 meaning no transition yet. Empty literals
+	// would match everywhere, so they are not looked for.
+	for i, literal := range literals {
+		if literal == "" {
+			continue
+		}
+
+		state := int32(0)
+
+		for j := 0; j < len(literal); j++ {
+			b := literal[j]
+			if fold {
+				b = lowerASCII(b)
+			}
+
+			if ac.next[state][b] == 0 {
+				ac.next = append(ac.next, [256]int32{})
+				ac.output = append(ac.output, nil)
+				ac.next[state][b] = int32(len(ac.next) - 1)
+			}
+
+			state = ac.next[state][b]
+		}
+
+		ac.output[state] = append(ac.output[state], i)
+		ac.lengths[i] = len(literal)
+	}
+
+	// breadth first, so the failure state of every state is done before it
+	fail := make([]int32, len(ac.next))
+	var queue []int32
+
+	for b := 0; b < 256; b++ {
+		if s := ac.next[0][b]; s != 0 {
+			queue = append(queue, s)
+		}
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		ac.output[state] = append(ac.output[state], ac.output[fail[state]]...)
+
+		for b := 0; b < 256; b++ {
+			s := ac.next[state][b]
+			if s == 0 {
+				ac.next[state][b] = ac.next[fail[state]][b]
+				continue
+			}
+
+			fail[s] = ac.next[fail[state]][b]
+			queue = append(queue, s)
+		}
+	}
+
+	return ac
+}
+
+// findAll calls fn with every occurrence of a literal in data, overlapping
+// ones included, in the order they end.
+func (ac *ahoCorasick) findAll(data []byte, fn func(literal int, from int, to int)) {
+	state := int32(0)
+
+	for i, b := range data {
+		if ac.fold {
+			b = lowerASCII(b)
+		}
+
+		state = ac.next[state][b]
+
+		for _, literal := range ac.output[state] {
+			fn(literal, i+1-ac.lengths[literal], i+1)
+		}
+	}
+}
+
+// literalMatcher finds the matches of the literal rules of a grepper.
+type literalMatcher struct {
+	exact       *ahoCorasick
+	exactRules  []int
+	folded      *ahoCorasick
+	foldedRules []int
+}
+
+// newLiteralMatcher returns a matcher for the rules with the Literal flag, or
+// nil if there are none.
+func newLiteralMatcher(rules []Rule) *literalMatcher {
+	var exact, folded []string
+	m := &literalMatcher{}
+
+	for i, rule := range rules {
+		if rule.Flags&Literal == 0 {
+			continue
+		}
+
+		if rule.Flags&Caseless != 0 {
+			folded = append(folded, rule.Expression)
+			m.foldedRules = append(m.foldedRules, i)
+		} else {
+			exact = append(exact, rule.Expression)
+			m.exactRules = append(m.exactRules, i)
+		}
+	}
+
+	if len(exact) == 0 && len(folded) == 0 {
+		return nil
+	}
+
+	m.exact = newAhoCorasick(exact, false)
+	m.folded = newAhoCorasick(folded, true)
+
+	return m
+}
+
+// findAll returns the locations of the matches in data by rule, in the same
+// form as regexp.FindAllIndex. As with regexps, every match of a rule starts
+// after the end of the previous one.
+func (m *literalMatcher) findAll(data []byte) map[int][][]int {
+	matches := make(map[int][][]int)
+
+	add := func(rule int, from int, to int) {
+		if found := matches[rule]; len(found) > 0 && from < found[len(found)-1][1] {
+			return
+		}
+
+		matches[rule] = append(matches[rule], []int{from, to})
+	}
+
+	m.exact.findAll(data, func(literal int, from int, to int) {
+		add(m.exactRules[literal], from, to)
+	})
+
+	m.folded.findAll(data, func(literal int, from int, to int) {
+		add(m.foldedRules[literal], from, to)
+	})
+
+	return matches
+}
@@ -0,0 +1,66 @@
+package grep
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestAhoCorasick(t *testing.T) {
+	tests := []struct {
+		literals []string
+		fold     bool
+		data     string
+		// want are the literals found, as literal@from-to in the order they
+		// end
+		want string
+	}{
+		{[]string{"he", "she", "his", "hers"}, false, "ushers", "[1@1-4 0@2-4 3@2-6]"},
+		{[]string{"aa"}, false, "aaaa", "[0@0-2 0@1-3 0@2-4]"},
+		{[]string{"Key"}, true, "KEY key kEy", "[0@0-3 0@4-7 0@8-11]"},
+		{[]string{"Key"}, false, "KEY key Key", "[0@8-11]"},
+		{[]string{"", "b"}, false, "abc", "[1@1-2]"},
+		{nil, false, "abc", "[]"},
+	}
+
+	for _, test := range tests {
+		var found []string
+		newAhoCorasick(test.literals, test.fold).findAll([]byte(test.data), func(literal int, from int, to int) {
+			found = append(found, fmt.Sprintf("%d@%d-%d", literal, from, to))
+		})
+
+		if got := fmt.Sprint(found); got != test.want {
+			t.Errorf("%q in %q: found %s, want %s", test.literals, test.data, got, test.want)
+		}
+	}
+}
+
+func TestLiteralMatcher(t *testing.T) {
+	tests := []struct {
+		rules []Rule
+		data  string
+	}{
+		{[]Rule{{Expression: "aa", Flags: Literal}}, "aaaa"},
+		{[]Rule{{Expression: "aa", Flags: Literal}}, "aaaaa"},
+		{[]Rule{{Expression: "aba", Flags: Literal}}, "ababababa"},
+		{[]Rule{{Expression: "a.b", Flags: Literal}, {Expression: "A.B", Flags: Literal | Caseless}}, "a.b axb A.B a.B"},
+		{[]Rule{{Expression: "ab", Flags: Literal}, {Expression: "b", Flags: Literal}}, "abab"},
+		{[]Rule{{Expression: "", Flags: Literal}, {Expression: "x", Flags: Literal}}, "axa"},
+	}
+
+	for _, test := range tests {
+		matches := newLiteralMatcher(test.rules).findAll([]byte(test.data))
+
+		for i, rule := range test.rules {
+			// empty literals are not looked for
+			var want [][]int
+			if rule.Expression != "" {
+				want = regexp.MustCompile(rule.goExpression()).FindAllIndex([]byte(test.data), -1)
+			}
+
+			if got := fmt.Sprint(matches[i]); got != fmt.Sprint(want) {
+				t.Errorf("%q in %q: found %s, want %s", rule.Expression, test.data, got, fmt.Sprint(want))
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package grep
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// GlobalAllowlist is the name of the allowlist applying to every rule.
+const GlobalAllowlist = "global"
+
+// Allowlist tells matches which are known not to be secrets, to make up for
+// the lack of lookarounds to exclude them in expressions.
+type Allowlist struct {
+	// Matches are regexps on the text matched, or on the value assigned for
+	// keyword matches.
+	Matches []*regexp.Regexp
+	// Paths are regexps on the path of the file matched.
+	Paths []*regexp.Regexp
+	// StopWords drop the matches containing any of them, regardless of case.
+	StopWords []string
+}
+
+// allows tells whether the allowlist drops a match in the file at path.
+func (a *Allowlist) allows(path string, match string, secret string) bool {
+	for _, r := range a.Paths {
+		if r.MatchString(path) {
+			return true
+		}
+	}
+
+	for _, r := range a.Matches {
+		if r.MatchString(match) || (secret != "" && r.MatchString(secret)) {
+			return true
+		}
+	}
+
+	lower := strings.ToLower(match + "\n" + secret)
+	for _, word := range a.StopWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allowlists are allowlists by name. The global one applies to every rule,
+// others only to the rules naming them.
+type Allowlists map[string]*Allowlist
+
+// ParseAllowlists reads allowlists from an INI file with a section for every
+// allowlist, holding path and match regexps and stopword literals taken as
+// they are written, e.g.
+//
+//	[global]
+//	path = (^|/)testdata/
+//	stopword = example
+func ParseAllowlists(r io.Reader) (Allowlists, error) {
+	settings, err := readSettings(r)
+	if err != nil {
+		return nil, err
+	}
+
+	allowlists := make(Allowlists)
+
+	for _, s := range settings {
+		name := s.section
+		if name == "" {
+			return nil, fmt.Errorf("%s: setting outside of an allowlist", s.key)
+		}
+
+		a, ok := allowlists[name]
+		if !ok {
+			a = &Allowlist{}
+			allowlists[name] = a
+		}
+
+		switch s.key {
+		case "path", "match":
+			r, err := regexp.Compile(s.value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: expression '%s' is not valid: %s", name, s.value, err)
+			}
+
+			if s.key == "path" {
+				a.Paths = append(a.Paths, r)
+			} else {
+				a.Matches = append(a.Matches, r)
+			}
+
+		case "stopword":
+			a.StopWords = append(a.StopWords, strings.ToLower(s.value))
+
+		default:
+			return nil, fmt.Errorf("%s: unknown setting %s", name, s.key)
+		}
+	}
+
+	return allowlists, nil
+}
+
+// ReadAllowlists reads allowlists from a file.
+func ReadAllowlists(filename string) (Allowlists, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return ParseAllowlists(fd)
+}
+
+// Check returns an error if any of rules names an allowlist not in a.
+func (a Allowlists) Check(rules []Rule) error {
+	for _, rule := range rules {
+		if _, ok := a[rule.Allowlist]; rule.Allowlist != "" && !ok {
+			return fmt.Errorf("expression '%s' uses an unknown allowlist '%s'", rule.Expression, rule.Allowlist)
+		}
+	}
+
+	return nil
+}
+
+// allows tells whether the global allowlist, or the allowlist named by a
+// rule, drops a match in the file at path.
+func (a Allowlists) allows(allowlist string, path string, match string, secret string) bool {
+	if global, ok := a[GlobalAllowlist]; ok && global.allows(path, match, secret) {
+		return true
+	}
+
+	if named, ok := a[allowlist]; ok && allowlist != "" && named.allows(path, match, secret) {
+		return true
+	}
+
+	return false
+}
+
+// AllowlistOption drops the matches allowlisted, after matching.
+type AllowlistOption struct {
+	allowlists Allowlists
+}
+
+func (o *AllowlistOption) SkipFile(string, fs.FileInfo) bool {
+	return false
+}
+
+func (o *AllowlistOption) SkipFileContent([]byte) bool {
+	return false
+}
+
+func (o *AllowlistOption) SetData(interface{}) {}
+
+// WithAllowlists drops the matches of every rule allowlisted by the global
+// allowlist, or by the one the rule names.
+func WithAllowlists(allowlists Allowlists) GrepOption {
+	return &AllowlistOption{
+		allowlists: allowlists,
+	}
+}
+
+// allowlistsOf returns the allowlists set by options, if any.
+func allowlistsOf(options []GrepOption) Allowlists {
+	for _, option := range options {
+		if o, ok := option.(*AllowlistOption); ok {
+			return o.allowlists
+		}
+	}
+
+	return nil
+}
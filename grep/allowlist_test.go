@@ -0,0 +1,130 @@
+package grep
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const testAllowlists = `
+# comment
+[global]
+path = (^|/)testdata/
+stopword = EXAMPLE
+
+[hex]
+match = ^0+$
+match = ^#[0-9a-f]{6}$
+; comment
+stopword = ; not a comment
+`
+
+func TestParseAllowlists(t *testing.T) {
+	allowlists, err := ParseAllowlists(strings.NewReader(testAllowlists))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	global, hex := allowlists[GlobalAllowlist], allowlists["hex"]
+	if global == nil || hex == nil || len(allowlists) != 2 {
+		t.Fatalf("parsed %d allowlists, want global and hex", len(allowlists))
+	}
+
+	if len(global.Paths) != 1 || strings.Join(global.StopWords, ",") != "example" {
+		t.Errorf("global: parsed %+v", global)
+	}
+
+	if len(hex.Matches) != 2 || hex.Matches[1].String() != "^#[0-9a-f]{6}$" || strings.Join(hex.StopWords, ",") != "; not a comment" {
+		t.Errorf("hex: parsed %+v", hex)
+	}
+
+	for _, content := range []string{
+		"path = x\n",
+		"[a]\npath = (\n",
+		"[a]\nallow = x\n",
+		"[a]\npath\n",
+	} {
+		if _, err := ParseAllowlists(strings.NewReader(content)); err == nil {
+			t.Errorf("%q: parsed without errors", content)
+		}
+	}
+}
+
+func TestAllowlists(t *testing.T) {
+	allowlists, err := ParseAllowlists(strings.NewReader(testAllowlists))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		allowlist string
+		path      string
+		match     string
+		secret    string
+		allows    bool
+	}{
+		{"", "testdata/keys.txt", "deadbeef", "", true},
+		{"", "src/testdata/keys.txt", "deadbeef", "", true},
+		{"", "src/keys.txt", "deadbeef", "", false},
+		{"", "src/keys.txt", "AKIAEXAMPLE", "", true},
+		{"", "src/keys.txt", "password = x", "Example123", true},
+		{"hex", "src/keys.txt", "00000000", "", true},
+		{"hex", "src/keys.txt", "color = ", "#ffffff", true},
+		{"hex", "src/keys.txt", "deadbeef", "", false},
+		{"hex", "testdata/keys.txt", "deadbeef", "", true},
+		// named allowlists only apply to the rules naming them
+		{"", "src/keys.txt", "00000000", "", false},
+		{"unknown", "src/keys.txt", "00000000", "", false},
+	}
+
+	for _, test := range tests {
+		if allows := allowlists.allows(test.allowlist, test.path, test.match, test.secret); allows != test.allows {
+			t.Errorf("%s: allows %q => %q in %s: %v, want %v", test.allowlist, test.match, test.secret, test.path, allows, test.allows)
+		}
+	}
+
+	if err := allowlists.Check([]Rule{{Expression: "a", Allowlist: "hex"}, {Expression: "b"}}); err != nil {
+		t.Errorf("known allowlists: %s", err)
+	}
+
+	if err := allowlists.Check([]Rule{{Expression: "a", Allowlist: "unknown"}}); err == nil {
+		t.Errorf("unknown allowlist: checked without errors")
+	}
+}
+
+func TestAllowlistedMatches(t *testing.T) {
+	allowlists, err := ParseAllowlists(strings.NewReader(testAllowlists))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules := []Rule{
+		{Expression: `\b[0-9a-f]{8}\b`, Allowlist: "hex"},
+		{Expression: `AKIA[0-9A-Z]{7,}`},
+	}
+
+	files := fstest.MapFS{
+		"src/keys.txt":      {Data: []byte("a = deadbeef\nb = 00000000\nc = AKIAEXAMPLE\nd = AKIA1234567\n")},
+		"testdata/keys.txt": {Data: []byte("a = deadbeef\n")},
+	}
+
+	grepper, err := NewReGrepperForRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := grepper.Grep(files, WithAllowlists(allowlists))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, result := range results {
+		got = append(got, result.Path+": "+result.Content)
+	}
+
+	want := []string{"src/keys.txt: deadbeef", "src/keys.txt: AKIA1234567"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("found %q, want %q", got, want)
+	}
+}
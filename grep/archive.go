@@ -0,0 +1,272 @@
+package grep
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+// ArchiveSeparator separates the path of an archive from the path of a file
+// inside it, e.g. lib/app.jar!/config.properties.
+const ArchiveSeparator = "!/"
+
+type archiveFormat int
+
+const (
+	archiveNone archiveFormat = iota
+	archiveZip
+	archiveTar
+	archiveTarGzip
+	archiveTarBzip2
+	archiveGzip
+	archiveBzip2
+)
+
+var archiveExtensions = []struct {
+	extension string
+	format    archiveFormat
+}{
+	{".tar.gz", archiveTarGzip},
+	{".tgz", archiveTarGzip},
+	{".tar.bz2", archiveTarBzip2},
+	{".tbz2", archiveTarBzip2},
+	{".tar", archiveTar},
+	{".gz", archiveGzip},
+	{".bz2", archiveBzip2},
+	{".zip", archiveZip},
+	{".jar", archiveZip},
+	{".war", archiveZip},
+	{".ear", archiveZip},
+	{".aar", archiveZip},
+	{".apk", archiveZip},
+	{".whl", archiveZip},
+	{".egg", archiveZip},
+	{".nupkg", archiveZip},
+}
+
+func archiveFormatOf(name string) archiveFormat {
+	name = strings.ToLower(name)
+
+	for _, e := range archiveExtensions {
+		if strings.HasSuffix(name, e.extension) {
+			return e.format
+		}
+	}
+
+	return archiveNone
+}
+
+// SplitArchivePath splits the path of a file found inside archives into the
+// path of the outermost archive and the path inside it. inner is empty for
+// files not inside an archive.
+func SplitArchivePath(name string) (outer string, inner string) {
+	i := strings.Index(name, ArchiveSeparator)
+	if i == -1 {
+		return name, ""
+	}
+
+	return name[:i], name[i+len(ArchiveSeparator):]
+}
+
+// ArchiveOption makes greps descend into archives and compressed files,
+// scanning the files inside them as if they were in a directory named after
+// the archive followed by ArchiveSeparator. Files inside archives are read
+// as they are scanned, like any other file, instead of being extracted
+// beforehand.
+type ArchiveOption struct {
+	maxDepth int
+	maxSize  int64
+}
+
+func (o *ArchiveOption) SkipFile(string, fs.FileInfo) bool {
+	return false
+}
+
+func (o *ArchiveOption) SkipFileContent([]byte) bool {
+	return false
+}
+
+func (o *ArchiveOption) SetData(interface{}) {}
+
+// WithArchives descends into archives up to maxDepth levels of nesting.
+// Zip archives whose contents add up to more than maxSize bytes are scanned
+// as regular files. The contents of tar archives and compressed files are
+// only known as they are read, so they are scanned up to maxSize bytes.
+func WithArchives(maxDepth int, maxSize int64) GrepOption {
+	return &ArchiveOption{
+		maxDepth: maxDepth,
+		maxSize:  maxSize,
+	}
+}
+
+// isArchive tells whether the file at name is an archive to descend into,
+// being depth levels deep in archives already.
+func (s *scanner) isArchive(name string, info fs.FileInfo, depth int) bool {
+	return s.archives != nil &&
+		depth < s.archives.maxDepth &&
+		info.Size() <= s.archives.maxSize &&
+		archiveFormatOf(name) != archiveNone
+}
+
+// scanArchive scans the files inside the archive at name, read from r, of
+// size bytes or -1 if not known. When r does not hold an archive that can be
+// opened, it returns false along with a reader for the data of r, so it can
+// be scanned as a regular file instead.
+func (s *scanner) scanArchive(name string, r io.Reader, size int64, depth int) (io.Reader, bool, error) {
+	format := archiveFormatOf(name)
+
+	if format == archiveZip {
+		return s.scanZip(name, r, size, depth)
+	}
+
+	br := bufio.NewReader(r)
+
+	dr, ok, err := decompressed(format, br)
+	if !ok {
+		return br, false, nil
+	}
+
+	if err != nil {
+		s.stats.skipped(name, "unreadable archive: "+err.Error())
+		return nil, true, nil
+	}
+
+	s.stats.archive()
+
+	if format == archiveGzip || format == archiveBzip2 {
+		base := path.Base(name)
+		base = base[:len(base)-len(path.Ext(base))]
+
+		return nil, true, s.scanEntry(name+ArchiveSeparator+base, &streamInfo{name: base, size: -1}, io.LimitReader(dr, s.archives.maxSize), depth+1)
+	}
+
+	return nil, true, s.scanTar(name, tar.NewReader(dr), depth)
+}
+
+// scanZip scans the files inside a zip archive. Zip archives are read from
+// their end, so those not read from a file are loaded in memory first.
+func (s *scanner) scanZip(name string, r io.Reader, size int64, depth int) (io.Reader, bool, error) {
+	ra, ok := r.(io.ReaderAt)
+	if !ok || size < 0 {
+		data, err := ioutil.ReadAll(io.LimitReader(r, s.archives.maxSize+1))
+
+		r = io.MultiReader(bytes.NewReader(data), r)
+		if err != nil || int64(len(data)) > s.archives.maxSize {
+			return r, false, nil
+		}
+
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return r, false, nil
+	}
+
+	var contents uint64
+	for _, f := range zr.File {
+		contents += f.UncompressedSize64
+	}
+
+	if contents > uint64(s.archives.maxSize) {
+		return r, false, nil
+	}
+
+	s.stats.archive()
+
+	return nil, true, s.walk(zr, name+ArchiveSeparator, depth+1)
+}
+
+// scanTar scans the files of a tar archive, in the order they are stored.
+func (s *scanner) scanTar(name string, tr *tar.Reader, depth int) error {
+	var size int64
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			s.stats.skipped(name, "unreadable archive: "+err.Error())
+			return nil
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entryPath := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if !fs.ValidPath(entryPath) {
+			continue
+		}
+
+		size += header.Size
+		if size > s.archives.maxSize {
+			s.stats.skipped(name, fmt.Sprintf("archive contents bigger than %d bytes", s.archives.maxSize))
+			return nil
+		}
+
+		err = s.scanEntry(name+ArchiveSeparator+entryPath, header.FileInfo(), tr, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decompressed returns the contents of a compressed archive, or the
+// archive itself if it is not compressed. It tells whether r holds data of
+// the format, as told by its first bytes, which are left unread otherwise.
+func decompressed(format archiveFormat, r *bufio.Reader) (io.Reader, bool, error) {
+	switch format {
+	case archiveTarGzip, archiveGzip:
+		if magic, _ := r.Peek(2); !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			return nil, false, nil
+		}
+
+		gr, err := gzip.NewReader(r)
+
+		return gr, true, err
+
+	case archiveTarBzip2, archiveBzip2:
+		if magic, _ := r.Peek(3); !bytes.Equal(magic, []byte("BZh")) {
+			return nil, false, nil
+		}
+
+		return bzip2.NewReader(r), true, nil
+
+	case archiveTar:
+		// both POSIX and GNU tar headers carry the ustar magic
+		if header, _ := r.Peek(263); len(header) < 263 || !bytes.Equal(header[257:262], []byte("ustar")) {
+			return nil, false, nil
+		}
+
+		return r, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// streamInfo is the fs.FileInfo of files inside compressed files, whose
+// size is not known until they are read.
+type streamInfo struct {
+	name string
+	size int64
+}
+
+func (i *streamInfo) Name() string       { return i.name }
+func (i *streamInfo) Size() int64        { return i.size }
+func (i *streamInfo) Mode() fs.FileMode  { return 0444 }
+func (i *streamInfo) ModTime() time.Time { return time.Time{} }
+func (i *streamInfo) IsDir() bool        { return false }
+func (i *streamInfo) Sys() interface{}   { return nil }
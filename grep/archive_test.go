@@ -0,0 +1,217 @@
+package grep
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"regexp"
+	"sort"
+	"testing"
+	"testing/fstest"
+)
+
+// sortedNames returns the names of files in order, so archives are built
+// the same way every time.
+func sortedNames(files map[string]string) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func zipOf(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+
+	w := zip.NewWriter(&buf)
+	for _, name := range sortedNames(files) {
+		content := files[name]
+
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f.Write([]byte(content))
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func tarOf(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+
+	w := tar.NewWriter(&buf)
+	for _, name := range sortedNames(files) {
+		content := files[name]
+
+		err := w.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w.Write([]byte(content))
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func gzipOf(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestArchives(t *testing.T) {
+	inner := zipOf(t, map[string]string{"conf/app.properties": "key=KEY1"})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		options []GrepOption
+		paths   []string
+	}{
+		{
+			"lib/app.zip",
+			zipOf(t, map[string]string{"a.txt": "KEY1", "dir/b.txt": "KEY2"}),
+			nil,
+			[]string{"lib/app.zip!/a.txt", "lib/app.zip!/dir/b.txt"},
+		},
+		{
+			"app.tar",
+			tarOf(t, map[string]string{"a.txt": "KEY1", "/abs/b.txt": "KEY2"}),
+			nil,
+			[]string{"app.tar!/a.txt", "app.tar!/abs/b.txt"},
+		},
+		{
+			"app.tar.gz",
+			gzipOf(t, tarOf(t, map[string]string{"lib/inner.jar": string(inner)})),
+			nil,
+			[]string{"app.tar.gz!/lib/inner.jar!/conf/app.properties"},
+		},
+		{
+			"dump.sql.gz",
+			gzipOf(t, []byte("insert KEY1")),
+			nil,
+			[]string{"dump.sql.gz!/dump.sql"},
+		},
+		{
+			// archives are descended into whatever the extensions grepped
+			"lib/app.jar",
+			zipOf(t, map[string]string{"app.properties": "KEY1", "App.class": "KEY2"}),
+			[]GrepOption{WithExcludedFileExtensions(".properties")},
+			[]string{"lib/app.jar!/app.properties"},
+		},
+		{
+			"lib/app.jar",
+			zipOf(t, map[string]string{"app.properties": "KEY1"}),
+			[]GrepOption{WithFileExtensions(".jar")},
+			nil,
+		},
+		{
+			// files that are not archives are scanned as regular files, if
+			// their extension is grepped
+			"fake.zip",
+			[]byte("KEY1 not a zip"),
+			nil,
+			[]string{"fake.zip"},
+		},
+		{
+			"fake.tar.gz",
+			[]byte("KEY1 not a gzip"),
+			nil,
+			[]string{"fake.tar.gz"},
+		},
+		{
+			"fake.tar",
+			[]byte("KEY1 not a tar"),
+			[]GrepOption{WithExcludedFileExtensions(".txt")},
+			nil,
+		},
+		{
+			// nested archives past the depth limit are scanned as files
+			"app.tar.gz",
+			gzipOf(t, tarOf(t, map[string]string{"deep.tar": string(tarOf(t, map[string]string{"a.txt": "KEY1"}))})),
+			[]GrepOption{WithArchives(1, 1<<20)},
+			[]string{"app.tar.gz!/deep.tar"},
+		},
+		{
+			// files inside archives are read in chunks, like any other
+			"app.tar.gz",
+			gzipOf(t, tarOf(t, map[string]string{"big.txt": string(bytes.Repeat([]byte("filler\n"), 40)) + "KEY1"})),
+			[]GrepOption{WithArchives(2, 1<<20), WithChunkSize(32, 8)},
+			[]string{"app.tar.gz!/big.txt"},
+		},
+		{
+			// tar archives are scanned up to the size limit
+			"big.tar.gz",
+			gzipOf(t, tarOf(t, map[string]string{"a.txt": "KEY1", "b.txt": "KEY2 " + string(make([]byte, 4000))})),
+			[]GrepOption{WithArchives(2, 1000)},
+			[]string{"big.tar.gz!/a.txt"},
+		},
+	}
+
+	grepper := NewReGrepper([]*regexp.Regexp{regexp.MustCompile(`KEY[0-9]`)})
+
+	for _, test := range tests {
+		files := fstest.MapFS{test.name: {Data: test.data}}
+
+		options := test.options
+		if !hasArchiveOption(options) {
+			options = append(options, WithArchives(2, 1<<20))
+		}
+
+		results, err := grepper.Grep(files, options...)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+
+		var paths []string
+		for _, result := range results {
+			paths = append(paths, result.Path)
+		}
+
+		sort.Strings(paths)
+
+		if len(paths) != len(test.paths) {
+			t.Errorf("%s: found matches in %q, want %q", test.name, paths, test.paths)
+			continue
+		}
+
+		for i := range paths {
+			if paths[i] != test.paths[i] {
+				t.Errorf("%s: found matches in %q, want %q", test.name, paths, test.paths)
+				break
+			}
+		}
+	}
+}
+
+func hasArchiveOption(options []GrepOption) bool {
+	for _, option := range options {
+		if _, ok := option.(*ArchiveOption); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,193 @@
+package grep
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"unicode/utf8"
+)
+
+// classifySampleSize is how much of a file is looked at to classify it.
+const classifySampleSize = 8192
+
+// maxNonTextRatio is the fraction of non-text bytes over which invalid UTF-8
+// content is considered binary, letting Latin-1 and similar text through.
+const maxNonTextRatio = 0.3
+
+type magicNumber struct {
+	offset int
+	magic  []byte
+	name   string
+}
+
+var magicNumbers = []magicNumber{
+	{0, []byte("\x89PNG\r\n\x1a\n"), "PNG"},
+	{0, []byte("GIF87a"), "GIF"},
+	{0, []byte("GIF89a"), "GIF"},
+	{0, []byte("\xff\xd8\xff"), "JPEG"},
+	{0, []byte("\x00\x00\x01\x00"), "ICO"},
+	{0, []byte("%PDF-"), "PDF"},
+	{0, []byte("PK\x03\x04"), "ZIP"},
+	{0, []byte("PK\x05\x06"), "ZIP"},
+	{0, []byte("\x1f\x8b"), "gzip"},
+	{0, []byte("BZh91AY&SY"), "bzip2"},
+	{0, []byte("\xfd7zXZ\x00"), "xz"},
+	{0, []byte("7z\xbc\xaf\x27\x1c"), "7z"},
+	{0, []byte("Rar!\x1a\x07"), "RAR"},
+	{257, []byte("ustar"), "tar"},
+	{0, []byte("\x7fELF"), "ELF"},
+	{0, []byte("\xfe\xed\xfa\xce"), "Mach-O"},
+	{0, []byte("\xfe\xed\xfa\xcf"), "Mach-O"},
+	{0, []byte("\xce\xfa\xed\xfe"), "Mach-O"},
+	{0, []byte("\xcf\xfa\xed\xfe"), "Mach-O"},
+	{0, []byte("\xca\xfe\xba\xbe"), "Java class"},
+	{0, []byte("\x00asm"), "WebAssembly"},
+	{0, []byte("SQLite format 3\x00"), "SQLite"},
+	{0, []byte("wOFF"), "WOFF"},
+	{0, []byte("wOF2"), "WOFF2"},
+	{0, []byte("OTTO\x00"), "OpenType"},
+	{0, []byte("\x00\x01\x00\x00\x00"), "TrueType"},
+	{0, []byte("OggS\x00"), "Ogg"},
+	{0, []byte("fLaC"), "FLAC"},
+	{0, []byte("ID3\x03"), "MP3"},
+	{0, []byte("ID3\x04"), "MP3"},
+	{4, []byte("ftyp"), "MP4"},
+}
+
+// BOMs of the Unicode encodings recognized as text.
+var (
+	bomUTF8    = []byte("\xef\xbb\xbf")
+	bomUTF16LE = []byte("\xff\xfe")
+	bomUTF16BE = []byte("\xfe\xff")
+)
+
+// ClassifyContent tells whether data looks like text, and why not when it
+// does not. It looks for known binary formats, NUL bytes and invalid UTF-8,
+// recognizing UTF-16 text by its BOM or by the layout of its NUL bytes.
+func ClassifyContent(data []byte) (bool, string) {
+	sample := data[:MinInt(len(data), classifySampleSize)]
+
+	if bytes.HasPrefix(sample, bomUTF8) || bytes.HasPrefix(sample, bomUTF16LE) || bytes.HasPrefix(sample, bomUTF16BE) {
+		return true, ""
+	}
+
+	for _, m := range magicNumbers {
+		if len(sample) >= m.offset+len(m.magic) && bytes.Equal(sample[m.offset:m.offset+len(m.magic)], m.magic) {
+			return false, fmt.Sprintf("%s file", m.name)
+		}
+	}
+
+	if bytes.IndexByte(sample, 0) != -1 {
+		if utf16Layout(sample) != nil {
+			return true, ""
+		}
+
+		return false, "NUL bytes"
+	}
+
+	// do not let a rune cut by the end of the sample make it invalid
+	valid := sample
+	for i := 0; i < utf8.UTFMax-1 && len(valid) > 0 && !utf8.Valid(valid); i++ {
+		valid = valid[:len(valid)-1]
+	}
+
+	if utf8.Valid(valid) {
+		return true, ""
+	}
+
+	controls := 0
+	for _, b := range sample {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\b' && b != 0x1b {
+			controls++
+		}
+	}
+
+	if float64(controls) > maxNonTextRatio*float64(len(sample)) {
+		return false, "control characters"
+	}
+
+	if float64(invalidUTF8Bytes(valid)) > maxNonTextRatio*float64(len(sample)) {
+		return false, "invalid UTF-8"
+	}
+
+	return true, ""
+}
+
+// utf16Layout tells whether NUL bytes sit on alternate positions, as they
+// do in mostly ASCII UTF-16 text, and returns the byte order they imply.
+func utf16Layout(sample []byte) binary.ByteOrder {
+	if len(sample) < 4 {
+		return nil
+	}
+
+	var nulEven, nulOdd int
+
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+
+		if i%2 == 0 {
+			nulEven++
+		} else {
+			nulOdd++
+		}
+	}
+
+	half := len(sample) / 2
+	threshold := half * 4 / 10
+
+	// some non-ASCII characters have NUL bytes on the other side
+	switch {
+	case nulOdd > threshold && nulEven <= nulOdd/16:
+		return binary.LittleEndian
+	case nulEven > threshold && nulOdd <= nulEven/16:
+		return binary.BigEndian
+	}
+
+	return nil
+}
+
+func invalidUTF8Bytes(sample []byte) int {
+	invalid := 0
+
+	for len(sample) > 0 {
+		r, size := utf8.DecodeRune(sample)
+		if r == utf8.RuneError && size == 1 {
+			invalid++
+		}
+
+		sample = sample[size:]
+	}
+
+	return invalid
+}
+
+// TextFilterOption skips files whose content is not text, as told by
+// ClassifyContent.
+type TextFilterOption struct {
+	reason string
+}
+
+func (f *TextFilterOption) SkipFile(string, fs.FileInfo) bool {
+	return false
+}
+
+func (f *TextFilterOption) SkipFileContent(data []byte) bool {
+	isText, reason := ClassifyContent(data)
+	f.reason = reason
+
+	return !isText
+}
+
+func (f *TextFilterOption) SetData(interface{}) {}
+
+func (f *TextFilterOption) SkipReason() string {
+	return "binary: " + f.reason
+}
+
+// WithTextContent skips binary files.
+func WithTextContent() GrepOption {
+	return &TextFilterOption{}
+}
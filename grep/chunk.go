@@ -0,0 +1,283 @@
+package grep
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+const (
+	defaultChunkSize    = 8 << 20
+	defaultChunkOverlap = 64 << 10
+)
+
+// chunk is a piece of a file handed to a grepper. Files not bigger than the
+// chunk size are handed whole, in a single chunk; bigger files are handed in
+// consecutive chunks, each starting with the last bytes of the previous one
+// so matches crossing a chunk boundary can still be found.
+type chunk struct {
+	data []byte
+	// fresh is where the bytes not seen in previous chunks start in data,
+	// and overlap how many of its last bytes the next chunk starts with.
+	fresh   int
+	overlap int
+	// offset and line are the file offset and line number of data[0].
+	offset int64
+	line   int
+
+	first bool
+	last  bool
+
+	// decoding is the chain of decoders data went through, and origin where
+	// the encoded data it was decoded from is, for chunks of decoded data.
+	decoding []string
+	origin   *position
+
+	// location tells where data is inside a structured file, for chunks of
+	// units given by an Extractor.
+	location string
+
+	// scope tells which rules the chunk is matched against.
+	scope ruleScope
+
+	// transcoded tells whether data was transcoded from UTF-16, in which case
+	// rawOffset is the file offset data[0] was at before.
+	transcoded bool
+	rawOffset  int64
+
+	// last position mapped back to the file
+	mappedPos    int
+	mappedOffset int64
+
+	// last position whose line was counted, with its line number
+	countedPos  int
+	countedLine int
+}
+
+// ruleScope selects rules by whether they look for keywords. Files split
+// into units by an Extractor are matched against keyword rules as they are,
+// where the assignment templates of their type apply, and against the rest
+// of rules unit by unit.
+type ruleScope int
+
+const (
+	allRules ruleScope = iota
+	keywordRules
+	nonKeywordRules
+)
+
+// matches tells whether rule is matched against the chunk.
+func (c *chunk) matches(rule Rule) bool {
+	switch c.scope {
+	case keywordRules:
+		return rule.Keyword != ""
+	case nonKeywordRules:
+		return rule.Keyword == ""
+	}
+
+	return true
+}
+
+type position struct {
+	offset int64
+	line   int
+}
+
+// position returns the file offset and line number of data[pos]. Decoded
+// data is located where the encoded data it came from is.
+func (c *chunk) position(pos int) (int64, int) {
+	if c.origin != nil {
+		return c.origin.offset, c.origin.line
+	}
+
+	if c.transcoded {
+		return c.rawOffsetAt(pos), c.lineAt(pos)
+	}
+
+	return c.offset + int64(pos), c.lineAt(pos)
+}
+
+// rawOffsetAt maps a position of transcoded data back to the file. Matches
+// come mostly in order, so the last mapping is reused to count from.
+func (c *chunk) rawOffsetAt(pos int) int64 {
+	from, raw := 0, c.rawOffset
+
+	if c.mappedPos > 0 && c.mappedPos <= pos {
+		from, raw = c.mappedPos, c.mappedOffset
+	}
+
+	raw += utf16Len(c.data[from:pos])
+	c.mappedPos, c.mappedOffset = pos, raw
+
+	return raw
+}
+
+// where describes a place of the file a chunk belongs to, for comments.
+func (c *chunk) where(path string, line int) string {
+	if c.location != "" {
+		return fmt.Sprintf("%s:%d [%s]", path, line, c.location)
+	}
+
+	return fmt.Sprintf("%s:%d", path, line)
+}
+
+// decodingChain names the decoders a chunk went through, if any.
+func (c *chunk) decodingChain() string {
+	return strings.Join(c.decoding, ">")
+}
+
+// whole tells whether the chunk holds the whole file.
+func (c *chunk) whole() bool {
+	return c.first && c.last
+}
+
+// lineAt returns the line number of data[pos]. Matches come mostly in
+// order, so newlines are counted from the last position asked about,
+// forwards or backwards.
+func (c *chunk) lineAt(pos int) int {
+	if c.countedLine == 0 {
+		c.countedLine = c.line
+	}
+
+	if pos >= c.countedPos {
+		c.countedLine += bytes.Count(c.data[c.countedPos:pos], []byte{'\n'})
+	} else {
+		c.countedLine -= bytes.Count(c.data[pos:c.countedPos], []byte{'\n'})
+	}
+
+	c.countedPos = pos
+
+	return c.countedLine
+}
+
+// context returns the line around data[from:to], cutting it at some bytes
+// from both ends of the match.
+func (c *chunk) context(from, to int) []byte {
+	const maxLineDelta = 32
+
+	lineStart := bytes.LastIndexByte(c.data[:from], '\n') + 1
+
+	lineEnd := bytes.IndexByte(c.data[to:], '\n')
+	if lineEnd == -1 {
+		lineEnd = len(c.data)
+	} else {
+		lineEnd += to
+	}
+
+	if from-lineStart > maxLineDelta {
+		lineStart = from - maxLineDelta
+	}
+
+	if lineEnd-to > maxLineDelta {
+		lineEnd = to + maxLineDelta
+	}
+
+	return c.data[lineStart:lineEnd]
+}
+
+// ChunkOption sets how big files are split for scanning.
+type ChunkOption struct {
+	size    int
+	overlap int
+}
+
+func (o *ChunkOption) SkipFile(string, fs.FileInfo) bool {
+	return false
+}
+
+func (o *ChunkOption) SkipFileContent([]byte) bool {
+	return false
+}
+
+func (o *ChunkOption) SetData(interface{}) {}
+
+func (o *ChunkOption) ChunkSize() (int, int) {
+	return o.size, o.overlap
+}
+
+// WithChunkSize scans files bigger than size bytes in chunks of that size,
+// so they never need to be fully loaded in memory. Consecutive chunks share
+// overlap bytes, which bounds the length of matches found across chunks,
+// along with that of the values assigned to keywords.
+func WithChunkSize(size int, overlap int) GrepOption {
+	if overlap >= size {
+		overlap = size / 2
+	}
+
+	return &ChunkOption{
+		size:    size,
+		overlap: overlap,
+	}
+}
+
+// chunker is implemented by options setting how big files are split.
+type chunker interface {
+	ChunkSize() (int, int)
+}
+
+// readChunks reads r in chunks of size bytes, plus the overlap kept from
+// the previous chunk, calling fn with each of them. The first chunk has
+// already been read into head. The data of a chunk is only valid until fn
+// returns.
+func readChunks(head []byte, r io.Reader, size int, overlap int, utf16 *utf16Encoding, fn func(c *chunk) error) (int64, error) {
+	buf := make([]byte, overlap+size)
+	n := copy(buf, head)
+
+	c := &chunk{
+		data:  buf[:n],
+		line:  1,
+		first: true,
+		last:  n < size,
+	}
+
+	if utf16 != nil {
+		c.transcoded = true
+		c.rawOffset = int64(utf16.bom)
+	}
+
+	total := int64(n)
+
+	for {
+		if !c.last {
+			c.overlap = MinInt(overlap, len(c.data))
+		}
+
+		if err := fn(c); err != nil {
+			return total, err
+		}
+
+		if c.last {
+			return total, nil
+		}
+
+		kept := c.overlap
+		dropped := len(c.data) - kept
+
+		next := &chunk{
+			offset: c.offset + int64(dropped),
+			line:   c.line + bytes.Count(c.data[:dropped], []byte{'\n'}),
+			fresh:  kept,
+		}
+
+		if c.transcoded {
+			next.transcoded = true
+			next.rawOffset = c.rawOffsetAt(dropped)
+		}
+
+		copy(buf, c.data[dropped:])
+
+		n, err := io.ReadFull(r, buf[kept:kept+size])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return total, err
+		}
+
+		total += int64(n)
+
+		next.data = buf[:kept+n]
+		next.last = n < size
+
+		c = next
+	}
+}
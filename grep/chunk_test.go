@@ -0,0 +1,211 @@
+package grep
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+func TestReadChunks(t *testing.T) {
+	lines := strings.Repeat("line\nlonger line\n\n", 20)
+
+	tests := []struct {
+		data    string
+		size    int
+		overlap int
+	}{
+		{"", 8, 2},
+		{"short", 8, 2},
+		{"exactly8", 8, 2},
+		{"nine char", 8, 2},
+		{lines, 16, 4},
+		{lines, 16, 0},
+		{lines, 7, 6},
+		{lines, len(lines), 10},
+	}
+
+	for _, test := range tests {
+		data := []byte(test.data)
+		head := data[:MinInt(len(data), test.size)]
+
+		var fresh []byte
+		var chunks int
+
+		read, err := readChunks(head, bytes.NewReader(data[len(head):]), test.size, test.overlap, nil, func(c *chunk) error {
+			chunks++
+
+			if c.first != (c.offset == 0) {
+				t.Errorf("%d/%d: chunk at %d has first %v", test.size, test.overlap, c.offset, c.first)
+			}
+
+			if !bytes.Equal(c.data, data[c.offset:c.offset+int64(len(c.data))]) {
+				t.Errorf("%d/%d: chunk at %d does not hold the data at its offset", test.size, test.overlap, c.offset)
+			}
+
+			if want := 1 + bytes.Count(data[:c.offset], []byte{'\n'}); c.line != want {
+				t.Errorf("%d/%d: chunk at %d starts at line %d, want %d", test.size, test.overlap, c.offset, c.line, want)
+			}
+
+			// positions are asked about out of order too
+			for _, pos := range []int{len(c.data) / 2, len(c.data), 0, len(c.data) / 3, len(c.data) - 1} {
+				if pos < 0 {
+					continue
+				}
+
+				if want := 1 + bytes.Count(data[:c.offset+int64(pos)], []byte{'\n'}); c.lineAt(pos) != want {
+					t.Errorf("%d/%d: line of %d is %d, want %d", test.size, test.overlap, c.offset+int64(pos), c.lineAt(pos), want)
+				}
+			}
+
+			fresh = append(fresh, c.data[c.fresh:]...)
+
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if read != int64(len(data)) || !bytes.Equal(fresh, data) {
+			t.Errorf("%d/%d: read %d bytes, fresh data %q, want %q", test.size, test.overlap, read, fresh, data)
+		}
+
+		if len(data) > test.size && chunks < 2 {
+			t.Errorf("%d/%d: %d bytes read in %d chunks", test.size, test.overlap, len(data), chunks)
+		}
+	}
+}
+
+func TestChunkedMatches(t *testing.T) {
+	// matches sit across every boundary of 16 byte chunks, and in their
+	// overlaps
+	data := strings.Repeat("ab KEY1234 cd\nxy", 40)
+
+	files := fstest.MapFS{
+		"file.txt": {Data: []byte(data)},
+	}
+
+	whole, err := NewReGrepper([]*regexp.Regexp{regexp.MustCompile(`KEY[0-9]+`)}).Grep(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(whole) != 40 {
+		t.Fatalf("found %d matches in the whole file, want 40", len(whole))
+	}
+
+	tests := []struct {
+		size    int
+		overlap int
+	}{
+		{16, 8},
+		{32, 8},
+		{17, 12},
+		{64, 16},
+	}
+
+	for _, test := range tests {
+		chunked, err := NewReGrepper([]*regexp.Regexp{regexp.MustCompile(`KEY[0-9]+`)}).Grep(files, WithChunkSize(test.size, test.overlap))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(chunked) != len(whole) {
+			t.Errorf("%d/%d: found %d matches, want %d", test.size, test.overlap, len(chunked), len(whole))
+			continue
+		}
+
+		for i := range whole {
+			if chunked[i].Offset != whole[i].Offset || chunked[i].Line != whole[i].Line || chunked[i].Content != whole[i].Content {
+				t.Errorf("%d/%d: match %d is %q at %d:%d, want %q at %d:%d", test.size, test.overlap, i, chunked[i].Content, chunked[i].Offset, chunked[i].Line, whole[i].Content, whole[i].Offset, whole[i].Line)
+			}
+		}
+	}
+}
+
+func TestMmapChunkedMatches(t *testing.T) {
+	dir := t.TempDir()
+
+	// big enough to be mapped, and to span several chunks
+	data := strings.Repeat(strings.Repeat("x", 1000)+" KEY1234\n", 300)
+
+	err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(data), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := osfs.New(dir)
+	grepper := NewReGrepper([]*regexp.Regexp{regexp.MustCompile(`KEY[0-9]+`)})
+
+	stats := &ScanStats{}
+
+	mapped, err := grepper.Grep(files, WithChunkSize(64<<10, 1<<10), WithStats(stats))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := grepper.Grep(files, WithChunkSize(64<<10, 1<<10), WithoutMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.FilesChunked != 1 {
+		t.Errorf("%d mapped files scanned in chunks, want 1", stats.FilesChunked)
+	}
+
+	if len(mapped) != 300 || len(read) != 300 {
+		t.Fatalf("found %d matches in the mapped file and %d in the read one, want 300", len(mapped), len(read))
+	}
+
+	for i := range mapped {
+		if mapped[i].Offset != read[i].Offset || mapped[i].Line != read[i].Line {
+			t.Errorf("match %d is at %d:%d in the mapped file, and %d:%d in the read one", i, mapped[i].Offset, mapped[i].Line, read[i].Offset, read[i].Line)
+		}
+	}
+}
+
+func TestChunkedDecoding(t *testing.T) {
+	const size, overlap = 128, 32
+
+	// 20 characters, short enough to be seen whole in the overlap
+	blob := base64.StdEncoding.EncodeToString([]byte("KEY1234 secret"))
+
+	tests := []struct {
+		name string
+		at   int
+	}{
+		{"inside the first chunk", 10},
+		{"right before the overlap", size - overlap - 1},
+		{"at the start of the overlap", size - overlap},
+		{"inside the overlap", size - overlap + 1},
+		{"at the start of the second chunk", size},
+	}
+
+	for _, test := range tests {
+		data := strings.Repeat(" ", test.at) + blob + strings.Repeat(" ", 2*size)
+
+		files := fstest.MapFS{
+			"file.txt": {Data: []byte(data)},
+		}
+
+		results, err := NewReGrepper([]*regexp.Regexp{regexp.MustCompile(`KEY[0-9]+`)}).Grep(files, WithDecoding(1), WithChunkSize(size, overlap))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 {
+			t.Errorf("%s: found %d matches, want 1", test.name, len(results))
+			continue
+		}
+
+		if results[0].Decoding != "base64" || results[0].Offset != int64(test.at) {
+			t.Errorf("%s: found a match decoded by %q at %d, want base64 at %d", test.name, results[0].Decoding, results[0].Offset, test.at)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package grep
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Composite is a rule made of two rules, whose matches are reported as a
+// single finding when found close to each other in the same file.
+type Composite struct {
+	Name   string
+	First  Rule
+	Second Rule
+	// Within is the most bytes, or lines if Lines is set, the starts of both
+	// matches may be apart.
+	Within int
+	Lines  bool
+}
+
+// CompositePart tells the composite rule a rule is a part of.
+type CompositePart struct {
+	Composite string
+	// Part is 1 for the first rule of the composite, and 2 for the second.
+	Part int
+}
+
+//go:embed composites.txt
+var defaultComposites string
+
+// DefaultComposites returns the built-in composite rules.
+func DefaultComposites() []Composite {
+	composites, err := ParseComposites(strings.NewReader(defaultComposites))
+	if err != nil {
+		panic(err)
+	}
+
+	return composites
+}
+
+// setting is a key = value line of a section of an INI file.
+type setting struct {
+	section string
+	key     string
+	value   string
+}
+
+// readSettings reads the settings of rules files in INI format, like
+// composites.txt. Values are taken as they are written, without inline
+// comments, as # and ; are common in expressions. Lines starting with them
+// are comments.
+func readSettings(r io.Reader) ([]setting, error) {
+	var settings []setting
+
+	scanner := bufio.NewScanner(r)
+	section := ""
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+			continue
+		case line[0] == '[' && line[len(line)-1] == ']':
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("line %d: '%s' is not a setting", n, line)
+		}
+
+		settings = append(settings, setting{
+			section: section,
+			key:     strings.TrimSpace(line[:eq]),
+			value:   strings.TrimSpace(line[eq+1:]),
+		})
+	}
+
+	return settings, scanner.Err()
+}
+
+// ParseComposites reads composite rules in the format of composites.txt, an
+// INI file with a section for every composite rule.
+func ParseComposites(r io.Reader) ([]Composite, error) {
+	settings, err := readSettings(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var composites []Composite
+	index := make(map[string]int)
+
+	for _, s := range settings {
+		name := s.section
+		if name == "" {
+			return nil, fmt.Errorf("%s: setting outside of a composite rule", s.key)
+		}
+
+		i, ok := index[name]
+		if !ok {
+			i = len(composites)
+			index[name] = i
+			composites = append(composites, Composite{Name: name})
+		}
+
+		c := &composites[i]
+
+		switch s.key {
+		case "first", "second":
+			rule, err := ParseRule(s.value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+
+			if s.key == "first" {
+				c.First = rule
+			} else {
+				c.Second = rule
+			}
+
+		case "within":
+			c.Within, c.Lines, err = parseDistance(s.value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
+			}
+
+		default:
+			return nil, fmt.Errorf("%s: unknown setting %s", name, s.key)
+		}
+	}
+
+	for _, c := range composites {
+		if c.First.Expression == "" || c.Second.Expression == "" || c.Within == 0 {
+			return nil, fmt.Errorf("%s: composite rules need first, second and within", c.Name)
+		}
+	}
+
+	return composites, nil
+}
+
+// parseDistance parses distances like 200 bytes or 5 lines.
+func parseDistance(value string) (int, bool, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return 0, false, fmt.Errorf("distance '%s' is not a number of bytes or lines", value)
+	}
+
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || n <= 0 {
+		return 0, false, fmt.Errorf("distance '%s' is not a number of bytes or lines", value)
+	}
+
+	switch fields[1] {
+	case "byte", "bytes":
+		return n, false, nil
+	case "line", "lines":
+		return n, true, nil
+	}
+
+	return 0, false, fmt.Errorf("distance '%s' is not a number of bytes or lines", value)
+}
+
+// ReadComposites reads composite rules from a file.
+func ReadComposites(filename string) ([]Composite, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return ParseComposites(fd)
+}
+
+// CompositeRules returns the rules greppers need to match for composites,
+// which tell the part they are in their results.
+func CompositeRules(composites []Composite) []Rule {
+	var rules []Rule
+
+	for _, c := range composites {
+		first, second := c.First, c.Second
+		first.Part = &CompositePart{Composite: c.Name, Part: 1}
+		second.Part = &CompositePart{Composite: c.Name, Part: 2}
+
+		rules = append(rules, first, second)
+	}
+
+	return rules
+}
+
+// CompositeGrepper combines the matches of the parts of composite rules found
+// by another grepper, which must match the rules given by CompositeRules.
+// Combined findings take the PatternID of the first part of their composite.
+// Other matches are returned as they are.
+type CompositeGrepper struct {
+	grepper    Grepper
+	composites map[string]Composite
+}
+
+func NewCompositeGrepper(grepper Grepper, composites []Composite) *CompositeGrepper {
+	g := &CompositeGrepper{
+		grepper:    grepper,
+		composites: make(map[string]Composite),
+	}
+
+	for _, c := range composites {
+		g.composites[c.Name] = c
+	}
+
+	return g
+}
+
+func (g CompositeGrepper) Grep(fss interface{}, options ...GrepOption) ([]Result, error) {
+	found, err := g.grepper.Grep(fss, options...)
+
+	type fileComposite struct {
+		path      string
+		composite string
+	}
+
+	var results []Result
+	parts := make(map[fileComposite][2][]Result)
+	var order []fileComposite
+
+	for _, result := range found {
+		if result.Part == nil {
+			results = append(results, result)
+			continue
+		}
+
+		key := fileComposite{path: result.Path, composite: result.Part.Composite}
+
+		matches, ok := parts[key]
+		if !ok {
+			order = append(order, key)
+		}
+
+		matches[result.Part.Part-1] = append(matches[result.Part.Part-1], result)
+		parts[key] = matches
+	}
+
+	for _, key := range order {
+		results = append(results, g.combine(g.composites[key.composite], parts[key])...)
+	}
+
+	return results, err
+}
+
+// combine returns a finding for every match of the first part of c with the
+// closest match of the second part within its distance. Matches in decoded
+// data are all reported where their encoded span is, so they are only
+// combined with matches in the same span.
+func (g CompositeGrepper) combine(c Composite, parts [2][]Result) []Result {
+	var results []Result
+
+	distance := func(a Result, b Result) int64 {
+		d := a.Offset - b.Offset
+		if c.Lines {
+			d = int64(a.Line - b.Line)
+		}
+
+		if d < 0 {
+			return -d
+		}
+
+		return d
+	}
+
+	sameData := func(a Result, b Result) bool {
+		if a.Decoding != b.Decoding {
+			return false
+		}
+
+		return a.Decoding == "" || a.Location == b.Location && a.Offset == b.Offset
+	}
+
+	// the parts may not be the same text, which in decoded data is only
+	// told by their contents
+	sameText := func(a Result, b Result) bool {
+		if a.Decoding != "" {
+			return strings.Contains(a.Content, b.Content) || strings.Contains(b.Content, a.Content)
+		}
+
+		return a.Offset < b.Offset+int64(len(b.Content)) && b.Offset < a.Offset+int64(len(a.Content))
+	}
+
+	for _, first := range parts[0] {
+		closest := -1
+
+		for i, second := range parts[1] {
+			if !sameData(first, second) || sameText(first, second) {
+				continue
+			}
+
+			if distance(first, second) > int64(c.Within) {
+				continue
+			}
+
+			if closest == -1 || distance(first, second) < distance(first, parts[1][closest]) {
+				closest = i
+			}
+		}
+
+		if closest == -1 {
+			continue
+		}
+
+		second := parts[1][closest]
+
+		earlier := first
+		if second.Offset < first.Offset {
+			earlier = second
+		}
+
+		results = append(results, Result{
+			PatternID: first.PatternID,
+			Pattern:   c.Name,
+			Path:      first.Path,
+			Content:   first.Content + "\n" + second.Content,
+			Comment:   fmt.Sprintf("%s:%d: %s: [%s] near [%s]", first.Path, earlier.Line, c.Name, first.Content, second.Content),
+			Ref:       first.Ref,
+			Offset:    earlier.Offset,
+			Line:      earlier.Line,
+			Decoding:  earlier.Decoding,
+			Location:  earlier.Location,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Offset < results[j].Offset
+	})
+
+	return results
+}
+
+func (g CompositeGrepper) Release() {
+	g.grepper.Release()
+}
@@ -0,0 +1,128 @@
+package grep
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestParseComposites(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		first   string
+		second  string
+		within  int
+		lines   bool
+		err     bool
+	}{
+		{
+			"expressions taken verbatim",
+			"# comment\n[pair]\n; comment\nfirst = /key #[0-9]+/i\nsecond = [;=]secret ; x\nwithin = 3 lines\n",
+			"key #[0-9]+", "[;=]secret ; x", 3, true, false,
+		},
+		{"bytes", "[pair]\nfirst = a\nsecond = b\nwithin = 200 bytes\n", "a", "b", 200, false, false},
+		{"missing within", "[pair]\nfirst = a\nsecond = b\n", "", "", 0, false, true},
+		{"outside a section", "first = a\n", "", "", 0, false, true},
+		{"unknown setting", "[pair]\nthird = c\n", "", "", 0, false, true},
+		{"not a setting", "[pair]\nfirst\n", "", "", 0, false, true},
+		{"bad distance", "[pair]\nfirst = a\nsecond = b\nwithin = near\n", "", "", 0, false, true},
+	}
+
+	for _, test := range tests {
+		composites, err := ParseComposites(strings.NewReader(test.content))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: parsed without errors", test.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if len(composites) != 1 {
+			t.Errorf("%s: parsed %d composites, want 1", test.name, len(composites))
+			continue
+		}
+
+		c := composites[0]
+		if c.Name != "pair" || c.First.Expression != test.first || c.Second.Expression != test.second || c.Within != test.within || c.Lines != test.lines {
+			t.Errorf("%s: parsed %+v", test.name, c)
+		}
+	}
+
+	if len(DefaultComposites()) == 0 {
+		t.Errorf("no default composites")
+	}
+}
+
+func TestCompositeGrepper(t *testing.T) {
+	encode := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		within   int
+		lines    bool
+		combined []string
+	}{
+		{"near", "ID1234 = SK5678\n", 20, false, []string{"ID1234\nSK5678"}},
+		{"second first", "SK5678 = ID1234\n", 20, false, []string{"ID1234\nSK5678"}},
+		{"far", "ID1234" + strings.Repeat(" ", 30) + "SK5678\n", 20, false, nil},
+		{"lines", "ID1234\n\nSK5678\n", 2, true, []string{"ID1234\nSK5678"}},
+		{"too many lines", "ID1234\n\n\nSK5678\n", 2, true, nil},
+		{"closest", "ID1234 SK0000 SK5678\n", 20, false, []string{"ID1234\nSK0000"}},
+		{"same span", "blob: " + encode("ID1234 and SK5678, encoded") + "\n", 20, false, []string{"ID1234\nSK5678"}},
+		{"raw and decoded", "ID1234 " + encode("SK5678, encoded apart") + "\n", 20, false, nil},
+		{
+			"different spans",
+			encode("ID1234, encoded apart") + " " + encode("SK5678, encoded apart") + "\n",
+			100, false, nil,
+		},
+	}
+
+	for _, test := range tests {
+		composites := []Composite{{
+			Name:   "pair",
+			First:  Rule{Expression: `ID[0-9]{4}`},
+			Second: Rule{Expression: `SK[0-9]{4}`},
+			Within: test.within,
+			Lines:  test.lines,
+		}}
+
+		// the parts come after other rules
+		rules := append([]Rule{{Expression: `unrelated`}}, CompositeRules(composites)...)
+
+		grepper, err := NewReGrepperForRules(rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files := fstest.MapFS{"file.txt": {Data: []byte(test.content)}}
+
+		results, err := NewCompositeGrepper(grepper, composites).Grep(files, WithDecoding(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Content)
+
+			if result.PatternID != 1 || result.Part != nil {
+				t.Errorf("%s: combined %q with PatternID %d and part %v, want 1, the first part, and none", test.name, result.Content, result.PatternID, result.Part)
+			}
+		}
+
+		if strings.Join(got, ", ") != strings.Join(test.combined, ", ") {
+			t.Errorf("%s: combined %q, want %q", test.name, got, test.combined)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package grep
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io/fs"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// decoder finds spans of encoded data and decodes them. decode returns nil
+// for spans that turn out not to be valid encoded data.
+type decoder struct {
+	name   string
+	spans  *regexp.Regexp
+	decode func(span []byte) []byte
+}
+
+var decoders = []decoder{
+	{
+		name:   "base64",
+		spans:  regexp.MustCompile(`[A-Za-z0-9+/_-]{16,}={0,2}`),
+		decode: decodeBase64,
+	},
+	{
+		name:   "hex",
+		spans:  regexp.MustCompile(`(?:[0-9a-fA-F]{2}){8,}`),
+		decode: decodeHex,
+	},
+	{
+		name:   "url",
+		spans:  regexp.MustCompile(`[^\s"'<>%]*(?:%[0-9a-fA-F]{2}[^\s"'<>%]*)+`),
+		decode: decodeURL,
+	},
+}
+
+func decodeBase64(span []byte) []byte {
+	s := strings.TrimRight(string(span), "=")
+
+	encoding := base64.RawStdEncoding
+	if strings.ContainsAny(s, "-_") {
+		if strings.ContainsAny(s, "+/") {
+			return nil
+		}
+
+		encoding = base64.RawURLEncoding
+	}
+
+	decoded, err := encoding.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+
+	return decoded
+}
+
+func decodeHex(span []byte) []byte {
+	decoded, err := hex.DecodeString(string(span))
+	if err != nil {
+		return nil
+	}
+
+	return decoded
+}
+
+func decodeURL(span []byte) []byte {
+	decoded, err := url.QueryUnescape(string(span))
+	if err != nil {
+		return nil
+	}
+
+	return []byte(decoded)
+}
+
+// isDecodedText tells whether decoded data is text. Random data decodes from
+// strings that just look encoded, so this is stricter than ClassifyContent.
+func isDecodedText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// DecodeOption makes greps also look for matches inside base64, hex and URL
+// encoded spans of files, once decoded.
+type DecodeOption struct {
+	maxDepth int
+}
+
+func (o *DecodeOption) SkipFile(string, fs.FileInfo) bool {
+	return false
+}
+
+func (o *DecodeOption) SkipFileContent([]byte) bool {
+	return false
+}
+
+func (o *DecodeOption) SetData(interface{}) {}
+
+// WithDecoding decodes encoded spans of files and greps them, decoding again
+// the spans found in decoded data up to maxDepth times. Matches in decoded
+// data are reported at the location of the outermost encoded span.
+func WithDecoding(maxDepth int) GrepOption {
+	return &DecodeOption{
+		maxDepth: maxDepth,
+	}
+}
+
+// decodedChunks calls fn with a chunk for the decoded contents of every
+// encoded span found in c, and for those found in turn inside them.
+func (o *DecodeOption) decodedChunks(c *chunk, fn func(c *chunk) error) error {
+	if len(c.decoding) >= o.maxDepth {
+		return nil
+	}
+
+	// spans are decoded by the chunk they start in, including those starting
+	// right where the overlap with the next chunk does: only this chunk can
+	// tell them from the end of an earlier span, which is what the next one
+	// sees at its start
+	owned := len(c.data) - c.overlap
+
+	for _, d := range decoders {
+		for _, loc := range d.spans.FindAllIndex(c.data, -1) {
+			if loc[0] > owned || (loc[0] == 0 && c.fresh > 0) {
+				continue
+			}
+
+			decoded := d.decode(c.data[loc[0]:loc[1]])
+			if len(decoded) == 0 || !isDecodedText(decoded) || string(decoded) == string(c.data[loc[0]:loc[1]]) {
+				continue
+			}
+
+			offset, line := c.position(loc[0])
+
+			dc := &chunk{
+				data:     decoded,
+				line:     1,
+				first:    true,
+				last:     true,
+				decoding: append(append([]string(nil), c.decoding...), d.name),
+				origin:   &position{offset: offset, line: line},
+				location: c.location,
+				scope:    c.scope,
+			}
+
+			if err := fn(dc); err != nil {
+				return err
+			}
+
+			if err := o.decodedChunks(dc, fn); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,299 @@
+package grep
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// ErrNotExtractable is returned by extractors for files they handle by name
+// but whose content is better grepped as it is.
+var ErrNotExtractable = errors.New("content is not extractable")
+
+// Unit is a logical piece of text of a structured file, grepped on its own.
+type Unit struct {
+	// Location tells where the unit is inside the file, e.g. the JSON path
+	// of a value or the cell of a notebook.
+	Location string
+	Text     []byte
+	// Offset is where the unit starts in the content it was extracted from.
+	Offset int64
+}
+
+// Extractor splits files of a given format into the units of text worth
+// grepping.
+type Extractor interface {
+	Handles(name string) bool
+	Extract(content []byte) ([]Unit, error)
+}
+
+// ExtractOption makes greps match the units extractors split files into,
+// instead of their raw content. Rules looking for keywords are still matched
+// against the raw content, where the assignment templates of the file type
+// apply. Files no extractor handles, or that fail to be parsed, are grepped
+// as they are.
+type ExtractOption struct {
+	extractors []Extractor
+}
+
+func (o *ExtractOption) SkipFile(string, fs.FileInfo) bool {
+	return false
+}
+
+func (o *ExtractOption) SkipFileContent([]byte) bool {
+	return false
+}
+
+func (o *ExtractOption) SetData(interface{}) {}
+
+func (o *ExtractOption) extractor(name string) Extractor {
+	for _, e := range o.extractors {
+		if e.Handles(name) {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// WithExtractors greps files through the first of extractors handling them.
+func WithExtractors(extractors ...Extractor) GrepOption {
+	return &ExtractOption{
+		extractors: extractors,
+	}
+}
+
+// DefaultExtractors returns the extractors for notebooks, JSON, YAML, XML and
+// minified JavaScript.
+func DefaultExtractors() []Extractor {
+	return []Extractor{
+		NotebookExtractor{},
+		JSONExtractor{},
+		YAMLExtractor{},
+		XMLExtractor{},
+		MinifiedJSExtractor{},
+	}
+}
+
+// unitsOf turns the fields of a structured file into units. Values under a
+// key are grepped along with it, so key-value rules keep matching. Fields
+// reported more than once, like the keys merged into YAML mappings, make a
+// single unit.
+func unitsOf(fields []Field) []Unit {
+	type unitKey struct {
+		offset int64
+		text   string
+	}
+
+	units := make([]Unit, 0, len(fields))
+	seen := make(map[unitKey]bool)
+
+	for _, f := range fields {
+		text := f.Value
+		if f.Key != "" {
+			text = f.Key + ": " + f.Value
+		}
+
+		if seen[unitKey{f.Offset, text}] {
+			continue
+		}
+
+		seen[unitKey{f.Offset, text}] = true
+
+		units = append(units, Unit{
+			Location: f.Path,
+			Text:     []byte(text),
+			Offset:   f.Offset,
+		})
+	}
+
+	return units
+}
+
+func hasExtension(name string, extensions ...string) bool {
+	ext := strings.ToLower(path.Ext(name))
+
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+type JSONExtractor struct{}
+
+func (JSONExtractor) Handles(name string) bool {
+	return hasExtension(name, ".json")
+}
+
+func (JSONExtractor) Extract(content []byte) ([]Unit, error) {
+	fields, err := flattenJSON(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return unitsOf(fields), nil
+}
+
+type YAMLExtractor struct{}
+
+func (YAMLExtractor) Handles(name string) bool {
+	return hasExtension(name, ".yaml", ".yml")
+}
+
+func (YAMLExtractor) Extract(content []byte) ([]Unit, error) {
+	fields, err := flattenYAML(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return unitsOf(fields), nil
+}
+
+type XMLExtractor struct{}
+
+func (XMLExtractor) Handles(name string) bool {
+	return hasExtension(name, ".xml", ".config", ".plist", ".csproj", ".xaml")
+}
+
+func (XMLExtractor) Extract(content []byte) ([]Unit, error) {
+	fields, err := flattenXML(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return unitsOf(fields), nil
+}
+
+// NotebookExtractor splits Jupyter notebooks into the sources and text
+// outputs of their cells, leaving out images and other binary outputs.
+type NotebookExtractor struct{}
+
+func (NotebookExtractor) Handles(name string) bool {
+	return hasExtension(name, ".ipynb")
+}
+
+// notebookText matches the parts of a notebook holding text, made of lines
+// stored as arrays of strings.
+var notebookText = regexp.MustCompile(`^(cells\[\d+\]\.(?:source|outputs\[\d+\]\.(?:text|traceback|data\.(?:text/[^.]+|application/json|application/javascript))))(?:\[\d+\])?$`)
+
+func (NotebookExtractor) Extract(content []byte) ([]Unit, error) {
+	fields, err := flattenJSON(content)
+	if err != nil {
+		return nil, err
+	}
+
+	var units []Unit
+	index := make(map[string]int)
+
+	for _, f := range fields {
+		m := notebookText.FindStringSubmatch(f.Path)
+		if m == nil {
+			continue
+		}
+
+		location := m[1]
+
+		if i, ok := index[location]; ok {
+			units[i].Text = append(units[i].Text, f.Value...)
+			continue
+		}
+
+		index[location] = len(units)
+		units = append(units, Unit{
+			Location: location,
+			Text:     []byte(f.Value),
+			Offset:   f.Offset,
+		})
+	}
+
+	return units, nil
+}
+
+// MinifiedJSExtractor splits the long lines of minified JavaScript into
+// statements.
+type MinifiedJSExtractor struct{}
+
+// minifiedLineLength is the line length from which JavaScript is taken as
+// minified.
+const minifiedLineLength = 1000
+
+func (MinifiedJSExtractor) Handles(name string) bool {
+	return hasExtension(name, ".js", ".mjs", ".cjs")
+}
+
+func (MinifiedJSExtractor) Extract(content []byte) ([]Unit, error) {
+	longest, lineStart := 0, 0
+	for i, b := range content {
+		if b == '\n' {
+			if i-lineStart > longest {
+				longest = i - lineStart
+			}
+
+			lineStart = i + 1
+		}
+	}
+
+	if len(content)-lineStart > longest {
+		longest = len(content) - lineStart
+	}
+
+	if longest < minifiedLineLength {
+		return nil, ErrNotExtractable
+	}
+
+	var units []Unit
+
+	line, lineStart := 1, 0
+	start, startLine, startColumn := 0, 1, 1
+
+	emit := func(end int) {
+		text := strings.TrimSpace(string(content[start:end]))
+		if text != "" {
+			units = append(units, Unit{
+				Location: fmt.Sprintf("%d:%d", startLine, startColumn),
+				Text:     []byte(text),
+				Offset:   int64(start),
+			})
+		}
+
+		start = end
+		startLine, startColumn = line, end-lineStart+1
+	}
+
+	// string literals are kept whole
+	var quote byte
+
+	for i := 0; i < len(content); i++ {
+		b := content[i]
+
+		if b == '\n' {
+			line++
+			lineStart = i + 1
+		}
+
+		switch {
+		case quote != 0:
+			if b == '\\' {
+				i++
+			} else if b == quote {
+				quote = 0
+			}
+
+		case b == '"' || b == '\'' || b == '`':
+			quote = b
+
+		case b == ';' || b == '}' || b == '\n':
+			emit(i + 1)
+		}
+	}
+
+	emit(len(content))
+
+	return units, nil
+}
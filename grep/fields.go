@@ -0,0 +1,558 @@
+package grep
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Field is a value found in a structured file, along with the path of keys
+// leading to it, e.g. database.users[0].password.
+type Field struct {
+	Path string
+	// Key is the last key of Path, shared by the items of arrays.
+	Key   string
+	Value string
+	// Offset is where the value starts in the file.
+	Offset int64
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+func indexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// flattenJSON returns the scalar values of a JSON document.
+func flattenJSON(content []byte) ([]Field, error) {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.UseNumber()
+
+	var fields []Field
+
+	var walk func(path string, key string) error
+	walk = func(path string, key string) error {
+		start := tokenStart(content, dec.InputOffset())
+
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case json.Delim:
+			switch t {
+			case '{':
+				for dec.More() {
+					keyToken, err := dec.Token()
+					if err != nil {
+						return err
+					}
+
+					k, _ := keyToken.(string)
+
+					if err := walk(joinPath(path, k), k); err != nil {
+						return err
+					}
+				}
+
+			case '[':
+				for i := 0; dec.More(); i++ {
+					if err := walk(indexPath(path, i), key); err != nil {
+						return err
+					}
+				}
+			}
+
+			// closing delimiter
+			_, err = dec.Token()
+			return err
+
+		case string:
+			fields = append(fields, Field{Path: path, Key: key, Value: t, Offset: start})
+		case json.Number:
+			fields = append(fields, Field{Path: path, Key: key, Value: t.String(), Offset: start})
+		case bool:
+			fields = append(fields, Field{Path: path, Key: key, Value: strconv.FormatBool(t), Offset: start})
+		}
+
+		return nil
+	}
+
+	if err := walk("", ""); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// tokenStart skips the separators a JSON decoder leaves before a token.
+func tokenStart(content []byte, offset int64) int64 {
+	for offset < int64(len(content)) {
+		switch content[offset] {
+		case ' ', '\t', '\r', '\n', ':', ',':
+			offset++
+		default:
+			return offset
+		}
+	}
+
+	return offset
+}
+
+// flattenXML returns the attributes and text of the elements of a XML
+// document. Element paths do not tell apart siblings with the same name.
+func flattenXML(content []byte) ([]Field, error) {
+	dec := xml.NewDecoder(bytes.NewReader(content))
+	dec.Strict = false
+
+	var fields []Field
+	var path []string
+
+	for {
+		start := dec.InputOffset()
+
+		token, err := dec.Token()
+		if err == io.EOF {
+			return fields, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+
+			for _, attr := range t.Attr {
+				fields = append(fields, Field{
+					Path:   strings.Join(path, ".") + "@" + attr.Name.Local,
+					Key:    attr.Name.Local,
+					Value:  attr.Value,
+					Offset: start,
+				})
+			}
+
+		case xml.EndElement:
+			if len(path) > 0 {
+				path = path[:len(path)-1]
+			}
+
+		case xml.CharData:
+			text := strings.TrimSpace(string(t))
+			if text == "" || len(path) == 0 {
+				continue
+			}
+
+			// point at the text, not at the whitespace before it
+			start += int64(bytes.Index(t, []byte(text[:1])))
+
+			fields = append(fields, Field{
+				Path:   strings.Join(path, "."),
+				Key:    path[len(path)-1],
+				Value:  text,
+				Offset: start,
+			})
+		}
+	}
+}
+
+// flattenYAML returns the scalar values of the documents of a YAML file.
+// Keys merged into a mapping with << are reported under it too, while other
+// aliases are not, as their values are already reported at their anchor.
+func flattenYAML(content []byte) ([]Field, error) {
+	var fields []Field
+
+	lines := lineOffsets(content)
+
+	// offset returns the offset of a node, whose column counts characters
+	offset := func(n *yaml.Node) int64 {
+		if n.Line < 1 || n.Line > len(lines) {
+			return 0
+		}
+
+		start := lines[n.Line-1]
+
+		line := content[start:]
+		if n.Line < len(lines) {
+			line = content[start:lines[n.Line]]
+		}
+
+		column := 0
+		for i := range string(line) {
+			if column == n.Column-1 {
+				return start + int64(i)
+			}
+
+			column++
+		}
+
+		return start
+	}
+
+	pairs := make(map[*yaml.Node][][2]*yaml.Node)
+
+	nodes, maxNodes := 0, maxYAMLExpansion*len(content)
+
+	var walk func(n *yaml.Node, path string, key string, depth int) error
+	walk = func(n *yaml.Node, path string, key string, depth int) error {
+		if depth > maxYAMLDepth {
+			return fmt.Errorf("yaml nested deeper than %d levels", maxYAMLDepth)
+		}
+
+		nodes++
+		if nodes > maxNodes {
+			return fmt.Errorf("yaml expands to more than %d nodes", maxNodes)
+		}
+
+		switch n.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for i, child := range n.Content {
+				childPath := path
+				if n.Kind == yaml.SequenceNode {
+					childPath = indexPath(path, i)
+				}
+
+				if err := walk(child, childPath, key, depth+1); err != nil {
+					return err
+				}
+			}
+
+		case yaml.MappingNode:
+			for _, pair := range yamlPairs(n, pairs) {
+				k := pair[0].Value
+
+				if err := walk(pair[1], joinPath(path, k), k, depth+1); err != nil {
+					return err
+				}
+			}
+
+		case yaml.ScalarNode:
+			if n.Tag == "!!null" {
+				return nil
+			}
+
+			start := offset(n)
+
+			// nodes start at their anchor or tag, if any
+			for start < int64(len(content)) && (content[start] == '&' || content[start] == '!') {
+				for start < int64(len(content)) && !isYAMLSpace(content[start]) {
+					start++
+				}
+
+				for start < int64(len(content)) && isYAMLSpace(content[start]) {
+					start++
+				}
+			}
+
+			// block scalars start on the line after their indicator
+			if n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 && n.Line < len(lines) {
+				start = lines[n.Line]
+				for start < int64(len(content)) && (content[start] == ' ' || content[start] == '\t') {
+					start++
+				}
+			}
+
+			fields = append(fields, Field{Path: path, Key: key, Value: n.Value, Offset: start})
+		}
+
+		return nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+
+	for {
+		var doc yaml.Node
+
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return fields, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := walk(&doc, "", "", 0); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// maxYAMLDepth bounds the nesting of YAML documents, and maxYAMLExpansion
+// how many nodes they can be walked through for every byte of the file, as
+// merges of aliases can make them grow exponentially.
+const (
+	maxYAMLDepth     = 64
+	maxYAMLExpansion = 16
+)
+
+// yamlPairs returns the key and value nodes of a mapping, with the keys
+// merged into it with << first, unless the mapping has them too. The pairs of
+// every mapping are kept in cache, so those merged again and again are only
+// listed once.
+func yamlPairs(n *yaml.Node, cache map[*yaml.Node][][2]*yaml.Node) [][2]*yaml.Node {
+	if pairs, ok := cache[n]; ok {
+		return pairs
+	}
+
+	var own [][2]*yaml.Node
+	var merged [][2]*yaml.Node
+
+	keys := make(map[string]bool)
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+
+		if key.Tag == "!!merge" {
+			sources := []*yaml.Node{value}
+			if value.Kind == yaml.SequenceNode {
+				sources = value.Content
+			}
+
+			for _, source := range sources {
+				for source.Kind == yaml.AliasNode {
+					source = source.Alias
+				}
+
+				if source.Kind == yaml.MappingNode {
+					merged = append(merged, yamlPairs(source, cache)...)
+				}
+			}
+
+			continue
+		}
+
+		keys[key.Value] = true
+		own = append(own, [2]*yaml.Node{key, value})
+	}
+
+	var pairs [][2]*yaml.Node
+
+	for _, pair := range merged {
+		if !keys[pair[0].Value] {
+			keys[pair[0].Value] = true
+			pairs = append(pairs, pair)
+		}
+	}
+
+	pairs = append(pairs, own...)
+	cache[n] = pairs
+
+	return pairs
+}
+
+func isYAMLSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// lineOffsets returns the offset of every line of content.
+func lineOffsets(content []byte) []int64 {
+	offsets := []int64{0}
+
+	for i, b := range content {
+		if b == '\n' && i+1 < len(content) {
+			offsets = append(offsets, int64(i+1))
+		}
+	}
+
+	return offsets
+}
+
+// unquote removes the quotes around a value, unescaping double quoted ones.
+func unquote(value string) string {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '"' && value[len(value)-1] == '"':
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				return unquoted
+			}
+
+			return value[1 : len(value)-1]
+
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return value[1 : len(value)-1]
+		}
+	}
+
+	return value
+}
+
+// stripInlineComment removes a comment started by any of markers after a
+// value. Quoted values end with their closing quote.
+func stripInlineComment(value string, markers ...string) string {
+	if value == "" {
+		return value
+	}
+
+	if value[0] == '"' || value[0] == '\'' {
+		if end := strings.IndexByte(value[1:], value[0]); end != -1 {
+			return value[:end+2]
+		}
+
+		return value
+	}
+
+	for _, marker := range markers {
+		if i := strings.Index(value, " "+marker); i != -1 {
+			value = value[:i]
+		}
+	}
+
+	return strings.TrimSpace(value)
+}
+
+// configLines calls fn with every line of content which is not empty nor a
+// comment started by any of comments, along with its offset.
+func configLines(content []byte, comments string, fn func(line string, offset int64)) {
+	offset := int64(0)
+
+	for _, raw := range bytes.SplitAfter(content, []byte{'\n'}) {
+		lineOffset := offset
+		offset += int64(len(raw))
+
+		line := strings.TrimRight(string(raw), "\r\n")
+		trimmed := strings.TrimLeft(line, " \t")
+
+		if trimmed == "" || strings.IndexByte(comments, trimmed[0]) != -1 {
+			continue
+		}
+
+		fn(trimmed, lineOffset+int64(len(line)-len(trimmed)))
+	}
+}
+
+// valueOffset returns the offset of value in line, which starts at offset.
+func valueOffset(line string, value string, offset int64) int64 {
+	if i := strings.LastIndex(line, value); i != -1 && value != "" {
+		return offset + int64(i)
+	}
+
+	return offset
+}
+
+// flattenEnv returns the variables of a .env file.
+func flattenEnv(content []byte) ([]Field, error) {
+	var fields []Field
+
+	configLines(content, "#", func(line string, offset int64) {
+		assignment := strings.TrimPrefix(line, "export ")
+
+		i := strings.IndexByte(assignment, '=')
+		if i <= 0 {
+			return
+		}
+
+		key := strings.TrimSpace(assignment[:i])
+		raw := stripInlineComment(strings.TrimSpace(assignment[i+1:]), "#")
+
+		fields = append(fields, Field{
+			Path:   key,
+			Key:    key,
+			Value:  unquote(raw),
+			Offset: valueOffset(line, raw, offset),
+		})
+	})
+
+	return fields, nil
+}
+
+// flattenINI returns the values of an INI file, under the path
+// section.key.
+func flattenINI(content []byte) ([]Field, error) {
+	var fields []Field
+	var section string
+
+	configLines(content, "#;", func(line string, offset int64) {
+		if line[0] == '[' {
+			if end := strings.IndexByte(line, ']'); end != -1 {
+				section = strings.TrimSpace(line[1:end])
+			}
+
+			return
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i <= 0 {
+			return
+		}
+
+		key := strings.TrimSpace(line[:i])
+		raw := stripInlineComment(strings.TrimSpace(line[i+1:]), "#", ";")
+
+		fields = append(fields, Field{
+			Path:   joinPath(section, key),
+			Key:    key,
+			Value:  unquote(raw),
+			Offset: valueOffset(line, raw, offset),
+		})
+	})
+
+	return fields, nil
+}
+
+// flattenProperties returns the values of a Java properties file. Values
+// continued on the next lines are joined, whatever those lines hold, even
+// if they look like comments or are blank.
+func flattenProperties(content []byte) ([]Field, error) {
+	var fields []Field
+	continued := false
+
+	offset := int64(0)
+
+	for _, raw := range bytes.SplitAfter(content, []byte{'\n'}) {
+		lineOffset := offset
+		offset += int64(len(raw))
+
+		line := strings.TrimRight(string(raw), "\r\n")
+		trimmed := strings.TrimLeft(line, " \t")
+		lineOffset += int64(len(line) - len(trimmed))
+
+		if continued {
+			last := &fields[len(fields)-1]
+			last.Value += strings.TrimSuffix(trimmed, `\`)
+			continued = strings.HasSuffix(trimmed, `\`)
+
+			continue
+		}
+
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+			continue
+		}
+
+		i := strings.IndexAny(trimmed, "=: \t")
+		if i <= 0 {
+			continue
+		}
+
+		key := trimmed[:i]
+		value := strings.TrimLeft(trimmed[i:], " \t")
+		if value != "" && (value[0] == '=' || value[0] == ':') {
+			value = strings.TrimLeft(value[1:], " \t")
+		}
+
+		fields = append(fields, Field{
+			Path:   key,
+			Key:    key,
+			Value:  strings.TrimSuffix(value, `\`),
+			Offset: valueOffset(trimmed, value, lineOffset),
+		})
+
+		continued = strings.HasSuffix(value, `\`)
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,163 @@
+package grep
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// fieldsString describes fields as path=value@offset, one per line.
+func fieldsString(fields []Field) string {
+	var lines []string
+	for _, f := range fields {
+		lines = append(lines, fmt.Sprintf("%s=%s@%d", f.Path, f.Value, f.Offset))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name    string
+		flatten func([]byte) ([]Field, error)
+		content string
+		want    []string
+	}{
+		{
+			"yaml mapping",
+			flattenYAML,
+			"db:\n  user: admin # comment\n  password: \"s3cr#t\"\n",
+			[]string{"db.user=admin@12", "db.password=s3cr#t@40"},
+		},
+		{
+			"yaml anchor on a mapping",
+			flattenYAML,
+			"anchors: &a\n  key: v\n",
+			[]string{"anchors.key=v@19"},
+		},
+		{
+			"yaml merge",
+			flattenYAML,
+			"base: &b\n  user: u\n  pass: p\nprod:\n  <<: *b\n  pass: q\n",
+			[]string{"base.user=u@17", "base.pass=p@27", "prod.user=u@17", "prod.pass=q@52"},
+		},
+		{
+			"yaml merge of a list",
+			flattenYAML,
+			"a: &a {x: 1}\nb: &b {y: 2}\nc:\n  <<: [*a, *b]\n",
+			[]string{"a.x=1@10", "b.y=2@23", "c.x=1@10", "c.y=2@23"},
+		},
+		{
+			"yaml alias",
+			flattenYAML,
+			"a: &a secret\nb: *a\nc: !!str &c tagged\n",
+			[]string{"a=secret@6", "c=tagged@31"},
+		},
+		{
+			"yaml flow collections",
+			flattenYAML,
+			"creds: {user: admin, keys: [k1, 'k2']}\n",
+			[]string{"creds.user=admin@14", "creds.keys[0]=k1@28", "creds.keys[1]=k2@32"},
+		},
+		{
+			"yaml sequences",
+			flattenYAML,
+			"users:\n  - name: a\n    token: t1\n  - b\n",
+			[]string{"users[0].name=a@17", "users[0].token=t1@30", "users[1]=b@37"},
+		},
+		{
+			"yaml block scalars",
+			flattenYAML,
+			"key: |\n  line one\n  line two\nfolded: >\n  a\n  b\n",
+			[]string{"key=line one\nline two\n@9", "folded=a b\n@41"},
+		},
+		{
+			"yaml documents",
+			flattenYAML,
+			"a: 1\n---\na: 2\n...\n---\nb: ~\nc: null\n",
+			[]string{"a=1@3", "a=2@12"},
+		},
+		{
+			"yaml non-ASCII",
+			flattenYAML,
+			"clé: välue\nnext: v\n",
+			[]string{"clé=välue@6", "next=v@19"},
+		},
+		{
+			"ini",
+			flattenINI,
+			"top = 1\n[db]\n; comment\nuser = admin ; inline\npass = \"p;w\" # c\nurl: x=y\n",
+			[]string{"top=1@6", "db.user=admin@30", "db.pass=p;w@52", "db.url=x=y@67"},
+		},
+		{
+			"properties",
+			flattenProperties,
+			"! comment\na.b = 1\nkey:value\nspaced value\nlong = one \\\n  two\n",
+			[]string{"a.b=1@16", "key=value@22", "spaced=value@35", "long=one two@48"},
+		},
+		{
+			"properties continued on blank and comment lines",
+			flattenProperties,
+			"a = one \\\n\nb = two \\\n# not a comment\nc = three\n",
+			[]string{"a=one @4", "b=two # not a comment@15", "c=three@41"},
+		},
+		{
+			"env",
+			flattenEnv,
+			"# comment\nexport A=1\nB=\"two # three\" # c\nC=plain # c\n",
+			[]string{"A=1@19", "B=two # three@23", "C=plain@43"},
+		},
+		{
+			"json",
+			flattenJSON,
+			`{"a": {"b": ["x", 2, true, null]}}`,
+			[]string{"a.b[0]=x@13", "a.b[1]=2@18", "a.b[2]=true@21"},
+		},
+	}
+
+	for _, test := range tests {
+		fields, err := test.flatten([]byte(test.content))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+
+		if got, want := fieldsString(fields), strings.Join(test.want, "\n"); got != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, want)
+		}
+
+		for _, f := range fields {
+			if f.Offset < 0 || f.Offset > int64(len(test.content)) {
+				t.Errorf("%s: %s at %d, out of the content", test.name, f.Path, f.Offset)
+			}
+		}
+	}
+}
+
+// mergeBomb returns a YAML file whose every level merges the previous one
+// twice, doubling its size once walked.
+func mergeBomb(levels int) string {
+	var b strings.Builder
+
+	b.WriteString("a0: &a0 {k: v}\n")
+	for i := 1; i <= levels; i++ {
+		fmt.Fprintf(&b, "a%d: &a%d {l: {<<: *a%d}, r: {<<: *a%d}}\n", i, i, i-1, i-1)
+	}
+
+	return b.String()
+}
+
+func TestFlattenYAMLErrors(t *testing.T) {
+	tests := []string{
+		"a: [unclosed\n",
+		"a: *undefined\n",
+		"key: value\n  bad: indent\n",
+		mergeBomb(24),
+	}
+
+	for _, content := range tests {
+		if _, err := flattenYAML([]byte(content)); err == nil {
+			t.Errorf("%q: flattened without errors", content)
+		}
+	}
+}
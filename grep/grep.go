@@ -6,6 +6,35 @@ type Result struct {
 	Content   string
 	Comment   string
 	Pattern   string
+	Ref       string
+	Offset    int64
+	Line      int
+	// Decoding is the chain of decoders, separated by >, the content went
+	// through before matching, if any.
+	Decoding string
+	// Location is where the match is inside a structured file, e.g. the
+	// JSON path of the value it was found in.
+	Location string
+	// Keyword is the keyword of the rule that matched, if it was expanded
+	// from one.
+	Keyword string
+	// Secret is the value assigned to the keyword, for matches of keyword
+	// rules.
+	Secret string
+	// KeyType is the type of private key found, e.g. RSA or OpenSSH, for
+	// matches of private keys.
+	KeyType string
+	// Encrypted tells whether the private key found is protected with a
+	// passphrase, when it can be told.
+	Encrypted bool
+	// Part is the composite rule the match is a part of, for matches of
+	// their rules.
+	Part *CompositePart
+	// Confidence is how likely the match is a real secret, from 0 to 1, as
+	// scored by a ScoringGrepper. Matches not scored have 0.
+	Confidence float64
+	// Reasons are why the confidence of the match was lowered, if it was.
+	Reasons []string
 }
 
 type Grepper interface {
@@ -1,9 +1,9 @@
 package grep
 
 import (
-	"bytes"
 	"fmt"
-	"io/fs"
+	"regexp"
+	"sync"
 
 	"github.com/flier/gohs/hyperscan"
 )
@@ -12,32 +12,72 @@ type HyperscanGrepper struct {
 	hsDb       hyperscan.BlockDatabase
 	hsScratch  *hyperscan.Scratch
 	patternMap map[uint]string
+	rules      []Rule
+	prefilter  *prefilter
+
+	stream *hsStreamDatabase
+}
+
+// hsStreamDatabase is the stream mode database used for files scanned in
+// chunks. It is only compiled the first time such a file is found.
+type hsStreamDatabase struct {
+	once    sync.Once
+	compile func() (hyperscan.StreamDatabase, error)
+	db      hyperscan.StreamDatabase
+	err     error
 }
 
 func NewHyperscanGrepper(matches []string) (*HyperscanGrepper, error) {
-	var patterns []*hyperscan.Pattern
+	return NewHyperscanGrepperForRules(RulesOf(matches))
+}
+
+// NewHyperscanGrepperForRules creates a grepper matching every rule on the
+// files it applies to.
+func NewHyperscanGrepperForRules(rules []Rule) (*HyperscanGrepper, error) {
 	patternMap := make(map[uint]string)
+	for i, rule := range rules {
+		patternMap[uint(i)] = rule.Expression
+	}
 
 	compileflag, err := hyperscan.ParseCompileFlag("L")
 	if err != nil {
 		return nil, err
 	}
 
-	for i, m := range matches {
-		p := hyperscan.NewPattern(m, compileflag)
+	hsDb, compileStream, err := hsLiteralDatabases(rules, compileflag)
+	if err != nil {
+		return nil, err
+	}
+
+	if hsDb == nil {
+		var patterns []*hyperscan.Pattern
+
+		for i, rule := range rules {
+			m := rule.Expression
+			if rule.Flags&Literal != 0 {
+				m = regexp.QuoteMeta(m)
+			}
+
+			p := hyperscan.NewPattern(m, compileflag|hsFlags(rule.Flags))
+
+			if _, err := p.Info(); err != nil {
+				return nil, fmt.Errorf("expression '%s' is not valid: %s", rule.Expression, err)
+			}
 
-		if _, err := p.Info(); err != nil {
-			return nil, fmt.Errorf("expression '%s' is not valid: %s", m, err)
+			p.Id = i
+			patterns = append(patterns, p)
 		}
 
-		p.Id = i
-		patternMap[uint(p.Id)] = m
-		patterns = append(patterns, p)
-	}
+		hsDb, err = hyperscan.NewBlockDatabase(patterns...)
+		if err != nil {
+			return nil, err
+		}
 
-	hsDb, err := hyperscan.NewBlockDatabase(patterns...)
-	if err != nil {
-		return nil, err
+		compileStream = func() (hyperscan.StreamDatabase, error) {
+			// leftmost start of match needs a large horizon to be tracked
+			// over the whole stream
+			return hyperscan.NewLargeStreamDatabase(patterns...)
+		}
 	}
 
 	hsScratch, err := hyperscan.NewScratch(hsDb)
@@ -49,123 +89,232 @@ func NewHyperscanGrepper(matches []string) (*HyperscanGrepper, error) {
 		hsDb:       hsDb,
 		hsScratch:  hsScratch,
 		patternMap: patternMap,
+		rules:      rules,
+		prefilter:  newPrefilter(rules),
+		stream:     &hsStreamDatabase{compile: compileStream},
 	}, nil
 }
 
+// hsFlags returns the compile flags setting flags.
+func hsFlags(flags RuleFlags) hyperscan.CompileFlag {
+	var f hyperscan.CompileFlag
+
+	if flags&DotAll != 0 {
+		f |= hyperscan.DotAll
+	}
+
+	if flags&MultiLine != 0 {
+		f |= hyperscan.MultiLine
+	}
+
+	if flags&Caseless != 0 {
+		f |= hyperscan.Caseless
+	}
+
+	return f
+}
+
+func (hsg HyperscanGrepper) streamDatabase() (hyperscan.StreamDatabase, error) {
+	hsg.stream.once.Do(func() {
+		hsg.stream.db, hsg.stream.err = hsg.stream.compile()
+		if hsg.stream.err != nil {
+			return
+		}
+
+		if err := hsg.hsScratch.Realloc(hsg.stream.db); err != nil {
+			hsg.stream.err = fmt.Errorf("error allocating HS scratch: %s", err)
+		}
+	})
+
+	return hsg.stream.db, hsg.stream.err
+}
+
 func (hsg HyperscanGrepper) Grep(fss interface{}, options ...GrepOption) ([]Result, error) {
 	var results []Result
 
+	// where each match starts in the data it was found in, to tell apart
+	// matches in the same decoded span
+	var starts []uint64
+
 	type scanCtx struct {
-		inputData []byte
-		fileName  string
+		chunk    *chunk
+		fileName string
 	}
 
-	err := Walk(fss, func(path string, info fs.FileInfo, cberr error) error {
-		if cberr != nil {
-			return cberr
-		}
+	handler := hyperscan.MatchHandler(func(id uint, from, to uint64, flags uint, context interface{}) error {
+		ctx := context.(*scanCtx)
+		c := ctx.chunk
 
-		if info.IsDir() {
+		// the database holds the rules for every file
+		if !hsg.rules[id].Applies(ctx.fileName) || !c.matches(hsg.rules[id]) {
 			return nil
 		}
 
-		for _, option := range options {
-			if option.SkipFile(path) {
-				return nil
-			}
+		// matches may start before the bytes of the file still in memory
+		start := int(int64(from) - c.offset)
+		if start < 0 {
+			start = 0
 		}
 
-		content, err := ReadFile(fss, path)
-		if err != nil {
-			// to-do: log this
+		end := int(int64(to) - c.offset)
+
+		// rules requiring words are confirmed by finding them near the
+		// match
+		if hsg.prefilter != nil && hsg.prefilter.required[id] && !hsg.prefilter.near(int(id), c.data, start, end) {
 			return nil
 		}
 
-		for _, option := range options {
-			if option.SkipFileContent(content) {
+		// keyword matches are only worth reporting along with the value
+		// assigned to the keyword
+		var secret string
+		if hsg.rules[id].Keyword != "" {
+			var ok bool
+			if secret, ok = assignedValue(c.data, end, !c.last); !ok {
 				return nil
 			}
 		}
 
-		handler := hyperscan.MatchHandler(func(id uint, from, to uint64, flags uint, context interface{}) error {
-			const maxLineDelta = uint64(32)
-			ctx := context.(*scanCtx)
+		pattern, ok := hsg.patternMap[id]
+		if !ok {
+			pattern = "<unknown>"
+		}
 
-			inputData := ctx.inputData
+		offset, line := c.position(start)
+		match := c.data[start:end]
 
-			var lineStart uint64
-			var lineEnd uint64
+		comment := fmt.Sprintf("%s: [%s] %s", c.where(ctx.fileName, line), match, c.context(start, end))
+		if secret != "" {
+			comment += fmt.Sprintf(" => %q", secret)
+		}
+		if c.decoding != nil {
+			comment += fmt.Sprintf(" (%s)", c.decodingChain())
+		}
 
-			_lineStart := bytes.LastIndexByte(inputData[:from], '\n')
-			_lineEnd := bytes.IndexByte(inputData[to:], '\n')
+		results = append(results, Result{
+			PatternID: id,
+			Pattern:   pattern,
+			Path:      ctx.fileName,
+			Content:   string(match),
+			Comment:   comment,
+			Offset:    offset,
+			Line:      line,
+			Decoding:  c.decodingChain(),
+			Location:  c.location,
+			Keyword:   hsg.rules[id].Keyword,
+			Part:      hsg.rules[id].Part,
+			Secret:    secret,
+		})
+		starts = append(starts, from)
 
-			if _lineStart == -1 {
-				lineStart = 0
-			} else {
-				lineStart = uint64(_lineStart) + 1
-			}
+		return nil
+	})
 
-			if _lineEnd == -1 {
-				lineEnd = uint64(len(inputData)) - 1
-			} else {
-				lineEnd = to + uint64(_lineEnd)
-			}
+	// stream is open while a file is scanned in chunks. Files whose scan
+	// stops before their last chunk leave it open, so it is also closed
+	// before opening the next one and once done.
+	var stream hyperscan.Stream
 
-			if from-lineStart > maxLineDelta {
-				if from-maxLineDelta < 0 {
-					lineStart = 0
-				} else {
-					lineStart = from - maxLineDelta
-				}
-			}
+	closeStream := func() error {
+		if stream == nil {
+			return nil
+		}
+
+		err := stream.Close()
+		stream = nil
 
-			if lineEnd-to > maxLineDelta {
-				if to+maxLineDelta >= uint64(len(inputData)) {
-					lineEnd = uint64(len(inputData)) - 1
-				} else {
-					lineEnd = to + maxLineDelta
-				}
+		return err
+	}
+
+	ctx := &scanCtx{}
+
+	err := scanFiles(fss, options, func(path string, c *chunk) error {
+		ctx.chunk = c
+		ctx.fileName = path
+
+		if c.whole() {
+			return hsg.hsDb.Scan(c.data, hsg.hsScratch, handler, ctx)
+		}
+
+		if c.first {
+			if err := closeStream(); err != nil {
+				return err
 			}
 
-			line := inputData[lineStart:lineEnd]
-			pattern, ok := hsg.patternMap[id]
-			if !ok {
-				pattern = "<unknown>"
+			db, err := hsg.streamDatabase()
+			if err != nil {
+				return err
 			}
 
-			results = append(results, Result{
-				PatternID: id,
-				Pattern:   pattern,
-				Path:      path,
-				Content:   string(inputData[from:to]),
-				Comment:   fmt.Sprintf("%s: [%s] %s", ctx.fileName, inputData[from:to], line),
-			})
+			stream, err = db.Open(0, hsg.hsScratch, handler, ctx)
+			if err != nil {
+				return err
+			}
+		}
 
-			return nil
-		})
+		// every chunk scans from the middle of the overlap with the previous
+		// one to the middle of the overlap with the next, so matches are
+		// reported with half the overlap before them, and the values
+		// assigned to keywords with half of it after them
+		if err := stream.Scan(c.data[c.fresh-c.fresh/2 : len(c.data)-c.overlap/2]); err != nil {
+			closeStream()
+			return err
+		}
 
-		err = hsg.hsDb.Scan(
-			content,
-			hsg.hsScratch,
-			handler,
-			&scanCtx{
-				inputData: content,
-				fileName:  path,
-			},
-		)
+		if c.last {
+			return closeStream()
+		}
 
-		return err
+		return nil
 	})
 
+	if closeErr := closeStream(); err == nil {
+		err = closeErr
+	}
+
+	// hyperscan reports every end of a match, keep the longest one found at
+	// each start
+	type matchStart struct {
+		path     string
+		id       uint
+		offset   int64
+		decoding string
+		location string
+		start    uint64
+	}
+
+	keyOf := func(i int) matchStart {
+		match := results[i]
+		return matchStart{path: match.Path, id: match.PatternID, offset: match.Offset, decoding: match.Decoding, location: match.Location, start: starts[i]}
+	}
+
+	longest := make(map[matchStart]int)
+
+	for i, match := range results {
+		key := keyOf(i)
+
+		if j, ok := longest[key]; !ok || len(match.Content) > len(results[j].Content) {
+			longest[key] = i
+		}
+	}
+
+	allowlists := allowlistsOf(options)
+
 	var tmp []Result
 
 	for i, match := range results {
-		if i == (len(results)-1) || results[i+1].PatternID != match.PatternID || (len(results[i+1].Content) < len(match.Content) && results[i+1].PatternID == match.PatternID) {
-			tmp = append(tmp, match)
+		if longest[keyOf(i)] != i {
+			continue
 		}
+
+		// only whole matches are allowlisted
+		if allowlists.allows(hsg.rules[match.PatternID].Allowlist, match.Path, match.Content, match.Secret) {
+			continue
+		}
+
+		tmp = append(tmp, match)
 	}
 
-	results = tmp
+	results = firstMatches(tmp, hsg.rules)
 
 	return results, err
 }
@@ -173,4 +322,8 @@ func (hsg HyperscanGrepper) Grep(fss interface{}, options ...GrepOption) ([]Resu
 func (hsg HyperscanGrepper) Release() {
 	hsg.hsScratch.Free()
 	hsg.hsDb.Close()
+
+	if hsg.stream.db != nil {
+		hsg.stream.db.Close()
+	}
 }
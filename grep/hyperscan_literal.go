@@ -0,0 +1,45 @@
+//go:build hyperscan_v52 || hyperscan_v54
+
+package grep
+
+import (
+	"github.com/flier/gohs/hyperscan"
+)
+
+// hsLiteralDatabases compiles rules with the literal API of hyperscan 5.2 and
+// later when they are all literals, which compiles and scans faster than
+// escaping them into patterns. The database is nil otherwise.
+func hsLiteralDatabases(rules []Rule, flags hyperscan.CompileFlag) (hyperscan.BlockDatabase, func() (hyperscan.StreamDatabase, error), error) {
+	var literals hyperscan.Literals
+
+	for i, rule := range rules {
+		if rule.Flags&Literal == 0 {
+			return nil, nil, nil
+		}
+
+		// literals only take the caseless flag
+		literal := hyperscan.NewLiteral(rule.Expression, flags|hsFlags(rule.Flags&Caseless))
+		literal.Id = i
+		literals = append(literals, literal)
+	}
+
+	if len(literals) == 0 {
+		return nil, nil, nil
+	}
+
+	db, err := literals.Build(hyperscan.BlockMode)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	compileStream := func() (hyperscan.StreamDatabase, error) {
+		db, err := literals.Build(hyperscan.StreamMode | hyperscan.SomHorizonLargeMode)
+		if err != nil {
+			return nil, err
+		}
+
+		return db.(hyperscan.StreamDatabase), nil
+	}
+
+	return db.(hyperscan.BlockDatabase), compileStream, nil
+}
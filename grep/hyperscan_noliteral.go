@@ -0,0 +1,13 @@
+//go:build !hyperscan_v52 && !hyperscan_v54
+
+package grep
+
+import (
+	"github.com/flier/gohs/hyperscan"
+)
+
+// hsLiteralDatabases needs the literal API of hyperscan 5.2 and later, so
+// literals are escaped into patterns instead.
+func hsLiteralDatabases([]Rule, hyperscan.CompileFlag) (hyperscan.BlockDatabase, func() (hyperscan.StreamDatabase, error), error) {
+	return nil, nil, nil
+}
@@ -0,0 +1,241 @@
+package grep
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// greppers returns a grepper of every kind for rules, by name. Hyperscan is
+// left out when its library cannot compile them, e.g. when it is missing.
+func greppers(t *testing.T, rules []Rule) map[string]Grepper {
+	re, err := NewReGrepperForRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all := map[string]Grepper{"re": re}
+
+	hs, err := NewHyperscanGrepperForRules(rules)
+	if err != nil {
+		t.Logf("hyperscan not tested: %s", err)
+		return all
+	}
+
+	all["hyperscan"] = hs
+
+	return all
+}
+
+// matchesString describes results as path:offset:content, in order.
+func matchesString(results []Result) string {
+	var matches []string
+	for _, r := range results {
+		matches = append(matches, fmt.Sprintf("%s:%d:%s", r.Path, r.Offset, r.Content))
+	}
+
+	sort.Strings(matches)
+
+	return strings.Join(matches, "\n")
+}
+
+func TestGreppers(t *testing.T) {
+	chunked := strings.Repeat("ab KEY1234 cd\nxy", 10)
+
+	var chunkedWant []string
+	for i := 0; i < 10; i++ {
+		chunkedWant = append(chunkedWant, fmt.Sprintf("file.txt:%d:KEY1234", 3+16*i))
+	}
+
+	var passwords []string
+	for i := 0; i < 6; i++ {
+		passwords = append(passwords, fmt.Sprintf("file.txt:%d:password = ", 20*i))
+	}
+
+	allowlists := Allowlists{
+		GlobalAllowlist: {StopWords: []string{"example"}},
+		"hex":           {Matches: []*regexp.Regexp{regexp.MustCompile(`^0+$`)}},
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		rules   []Rule
+		options []GrepOption
+		want    []string
+	}{
+		{
+			"caseless",
+			"SeCrEt secret",
+			[]Rule{{Expression: `secret`, Flags: Caseless}},
+			nil,
+			[]string{"file.txt:0:SeCrEt", "file.txt:7:secret"},
+		},
+		{
+			"dot all",
+			"a\nb a-b",
+			[]Rule{{Expression: `a.b`, Flags: DotAll}},
+			nil,
+			[]string{"file.txt:0:a\nb", "file.txt:4:a-b"},
+		},
+		{
+			"multi line",
+			"x\nkey\nkey y\n",
+			[]Rule{{Expression: `^key$`, Flags: MultiLine}},
+			nil,
+			[]string{"file.txt:2:key"},
+		},
+		{
+			// rules made only of literals are compiled with the literal API
+			// where available
+			"literals",
+			"a.b axb A.B",
+			[]Rule{{Expression: `a.b`, Flags: Literal}, {Expression: `x`, Flags: Literal | Caseless}},
+			nil,
+			[]string{"file.txt:0:a.b", "file.txt:5:x"},
+		},
+		{
+			"literal among expressions",
+			"a.b axb",
+			[]Rule{{Expression: `a.b`, Flags: Literal}, {Expression: `x[a-z]`}},
+			nil,
+			[]string{"file.txt:0:a.b", "file.txt:5:xb"},
+		},
+		{
+			"single match",
+			"KEY1 KEY2 KEY3",
+			[]Rule{{Expression: `KEY[0-9]`, Flags: SingleMatch}},
+			nil,
+			[]string{"file.txt:0:KEY1"},
+		},
+		{
+			// the longest match is kept at every start, whatever the ends
+			// hyperscan reports
+			"longest match",
+			"KEY123456 KEY7",
+			[]Rule{{Expression: `KEY[0-9]+`}},
+			nil,
+			[]string{"file.txt:0:KEY123456", "file.txt:10:KEY7"},
+		},
+		{
+			// matches cross every chunk boundary and overlap
+			"chunks",
+			chunked,
+			[]Rule{{Expression: `KEY[0-9]+`}},
+			[]GrepOption{WithChunkSize(16, 8)},
+			chunkedWant,
+		},
+		{
+			"chunks with an odd overlap",
+			chunked,
+			[]Rule{{Expression: `KEY[0-9]+`}},
+			[]GrepOption{WithChunkSize(17, 11)},
+			chunkedWant,
+		},
+		{
+			// chunks are scanned up to the middle of their overlap, which
+			// cuts every match short until the next chunk is scanned
+			"matches cut by chunks",
+			chunked,
+			[]Rule{{Expression: `KEY[0-9]+`}},
+			[]GrepOption{WithChunkSize(16, 14)},
+			chunkedWant,
+		},
+		{
+			// and keyword matches have the rest of the overlap after them,
+			// for the values assigned
+			"keyword values in chunks",
+			strings.Repeat("password = hunter22\n", 6),
+			DefaultTemplates().Expand("password"),
+			[]GrepOption{WithChunkSize(32, 16)},
+			passwords,
+		},
+		{
+			"keyword values in small chunks",
+			strings.Repeat("password = hunter22\n", 6),
+			DefaultTemplates().Expand("password"),
+			[]GrepOption{WithChunkSize(24, 16)},
+			passwords,
+		},
+		{
+			"required words",
+			"token = deadbeef\n\n\n\n\nother = cafebabe\n",
+			[]Rule{{Expression: `[0-9a-f]{8}`, RequiredWords: []string{"TOKEN"}}},
+			nil,
+			[]string{"file.txt:8:deadbeef"},
+		},
+		{
+			"allowlists",
+			"0000 00a1 example99",
+			[]Rule{{Expression: `[0-9a]{4}`, Allowlist: "hex"}, {Expression: `0{4}`}, {Expression: `example[0-9]+`}},
+			[]GrepOption{WithAllowlists(allowlists)},
+			[]string{"file.txt:0:0000", "file.txt:5:00a1"},
+		},
+	}
+
+	for _, test := range tests {
+		files := fstest.MapFS{
+			"file.txt": {Data: []byte(test.content)},
+		}
+
+		want := append([]string(nil), test.want...)
+		sort.Strings(want)
+
+		for name, grepper := range greppers(t, test.rules) {
+			results, err := grepper.Grep(files, test.options...)
+			grepper.Release()
+
+			if err != nil {
+				t.Errorf("%s, %s: %s", test.name, name, err)
+				continue
+			}
+
+			if got, want := matchesString(results), strings.Join(want, "\n"); got != want {
+				t.Errorf("%s, %s: got\n%s\nwant\n%s", test.name, name, got, want)
+			}
+		}
+	}
+}
+
+func TestHyperscanStreamDatabase(t *testing.T) {
+	hs, err := NewHyperscanGrepperForRules([]Rule{{Expression: `KEY[0-9]+`}})
+	if err != nil {
+		t.Skipf("hyperscan not tested: %s", err)
+	}
+
+	defer hs.Release()
+
+	small := fstest.MapFS{
+		"small.txt": {Data: []byte("ab KEY1234 cd")},
+	}
+
+	results, err := hs.Grep(small, WithChunkSize(64, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || hs.stream.db != nil {
+		t.Errorf("found %d matches in a whole file, stream database compiled %v, want 1 and false", len(results), hs.stream.db != nil)
+	}
+
+	// the scratch space is reallocated for the stream database once it is
+	// compiled, and whole files still scan with the block one
+	files := fstest.MapFS{
+		"big.txt":   {Data: []byte(strings.Repeat("ab KEY1234 cd\nxy", 10))},
+		"small.txt": {Data: []byte("ab KEY1234 cd")},
+	}
+
+	for i := 0; i < 2; i++ {
+		results, err = hs.Grep(files, WithChunkSize(64, 16))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 11 || hs.stream.db == nil {
+			t.Errorf("grep %d: found %d matches, stream database compiled %v, want 11 and true", i, len(results), hs.stream.db != nil)
+		}
+	}
+}
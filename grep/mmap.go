@@ -0,0 +1,103 @@
+package grep
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+)
+
+// mmapMinSize is the size from which files are mapped instead of read.
+// Mapping smaller files costs more than reading them.
+const mmapMinSize = 64 << 10
+
+// MmapOption disables memory mapping of files.
+type MmapOption struct{}
+
+func (o *MmapOption) SkipFile(string, fs.FileInfo) bool {
+	return false
+}
+
+func (o *MmapOption) SkipFileContent([]byte) bool {
+	return false
+}
+
+func (o *MmapOption) SetData(interface{}) {}
+
+// WithoutMmap reads every file into memory, even those that could be
+// mapped.
+func WithoutMmap() GrepOption {
+	return &MmapOption{}
+}
+
+// fder is implemented by files backed by an OS file descriptor.
+type fder interface {
+	Fd() uintptr
+}
+
+// mmapFile maps a file of fss in memory, if it lives in the OS filesystem.
+// It returns nil when the file has to be read instead. The mapping must be
+// released with syscall.Munmap.
+func mmapFile(fss interface{}, path string, info fs.FileInfo) []byte {
+	if !info.Mode().IsRegular() || info.Size() < mmapMinSize {
+		return nil
+	}
+
+	var file io.ReadCloser
+	var err error
+
+	if osPath, ok := billyOSPath(fss, path); ok {
+		file, err = os.Open(osPath)
+	} else {
+		file, err = OpenFile(fss, path)
+	}
+
+	if err != nil {
+		return nil
+	}
+
+	defer file.Close()
+
+	f, ok := file.(fder)
+	if !ok {
+		return nil
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil
+	}
+
+	return data
+}
+
+// billyOSPath returns the OS path of a file of a billy filesystem backed by
+// osfs. Files of chrooted billy filesystems do not expose their descriptor,
+// so they are opened again from the OS.
+func billyOSPath(fss interface{}, path string) (string, bool) {
+	bfs, ok := fss.(billy.Filesystem)
+	if !ok {
+		return "", false
+	}
+
+	// chroot and polyfill helpers wrap the actual filesystem
+	var underlying billy.Basic = bfs
+	for {
+		wrapper, ok := underlying.(interface{ Underlying() billy.Basic })
+		if !ok {
+			break
+		}
+
+		underlying = wrapper.Underlying()
+	}
+
+	if _, ok := underlying.(*osfs.OS); !ok {
+		return "", false
+	}
+
+	return filepath.Join(bfs.Root(), path), true
+}
@@ -0,0 +1,83 @@
+package grep
+
+// MultiGrepper greps with several greppers, e.g. a regex grepper along with
+// a StructuredGrepper, and returns the results of all of them. Results of a
+// grepper already found by a previous one are dropped.
+type MultiGrepper struct {
+	greppers []Grepper
+}
+
+func NewMultiGrepper(greppers ...Grepper) *MultiGrepper {
+	return &MultiGrepper{
+		greppers: greppers,
+	}
+}
+
+func (g MultiGrepper) Grep(fss interface{}, options ...GrepOption) ([]Result, error) {
+	var results []Result
+
+	for _, grepper := range g.greppers {
+		found, err := grepper.Grep(fss, options...)
+		results = appendNew(results, found)
+
+		if err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+// findingKey identifies a finding regardless of the grepper that reported
+// it: the file and the secret, or the content matched for rules without
+// one.
+type findingKey struct {
+	path     string
+	decoding string
+	secret   string
+}
+
+func keyOf(result Result) findingKey {
+	secret := result.Secret
+	if secret == "" {
+		secret = result.Content
+	}
+
+	return findingKey{path: result.Path, decoding: result.Decoding, secret: secret}
+}
+
+// appendNew appends the results found to results, except those of a finding
+// already in them. Greppers report the same finding at different offsets,
+// e.g. the keyword or the value assigned to it, so findings are the same if
+// their content overlaps.
+func appendNew(results, found []Result) []Result {
+	spans := make(map[findingKey][][2]int64)
+	for _, result := range results {
+		key := keyOf(result)
+		spans[key] = append(spans[key], [2]int64{result.Offset, result.Offset + int64(len(result.Content))})
+	}
+
+	for _, result := range found {
+		start, end := result.Offset, result.Offset+int64(len(result.Content))
+
+		duplicate := false
+		for _, span := range spans[keyOf(result)] {
+			if start <= span[1] && span[0] <= end {
+				duplicate = true
+				break
+			}
+		}
+
+		if !duplicate {
+			results = append(results, result)
+		}
+	}
+
+	return results
+}
+
+func (g MultiGrepper) Release() {
+	for _, grepper := range g.greppers {
+		grepper.Release()
+	}
+}
@@ -1,17 +1,24 @@
 package grep
 
 import (
+	"io/fs"
 	"net/http"
 	"strconv"
 	"strings"
 )
 
 type GrepOption interface {
-	SkipFile(string) bool
+	SkipFile(string, fs.FileInfo) bool
 	SkipFileContent([]byte) bool
 	SetData(interface{})
 }
 
+// SkipReasoner is implemented by options able to tell why they skipped the
+// last file they were asked about.
+type SkipReasoner interface {
+	SkipReason() string
+}
+
 type ExtensionFilterOption struct {
 	extensions []string
 	inverse    bool
@@ -27,7 +34,7 @@ func (f *ExtensionFilterOption) fileHasAnyExtension(path string) bool {
 	return false
 }
 
-func (f *ExtensionFilterOption) SkipFile(path string) bool {
+func (f *ExtensionFilterOption) SkipFile(path string, _ fs.FileInfo) bool {
 	/*
 		hasExtension		inverse		result
 		f					f			f
@@ -46,6 +53,14 @@ func (f *ExtensionFilterOption) SkipFileContent([]byte) bool {
 
 func (f *ExtensionFilterOption) SetData(interface{}) {}
 
+func (f *ExtensionFilterOption) SkipReason() string {
+	if f.inverse {
+		return "extension not included"
+	}
+
+	return "excluded extension"
+}
+
 func WithFileExtensions(extensions ...string) GrepOption {
 	return &ExtensionFilterOption{
 		extensions: extensions,
@@ -61,10 +76,11 @@ func WithExcludedFileExtensions(extensions ...string) GrepOption {
 }
 
 type FileContentFilterOption struct {
-	cb func([]byte) bool
+	cb     func([]byte) bool
+	reason string
 }
 
-func (f *FileContentFilterOption) SkipFile(string) bool {
+func (f *FileContentFilterOption) SkipFile(string, fs.FileInfo) bool {
 	return false
 }
 
@@ -74,26 +90,19 @@ func (f *FileContentFilterOption) SkipFileContent(data []byte) bool {
 
 func (f *FileContentFilterOption) SetData(interface{}) {}
 
-func WithPrintableContent() GrepOption {
-	return &FileContentFilterOption{
-		cb: func(data []byte) bool {
-			const checkSize = 64
-			const neededPrintable = int(0.75 * checkSize)
-
-			maxPos := MinInt(len(data), checkSize)
-			nReadable := 0
-
-			for i := 0; i < maxPos; i++ {
-				if strconv.IsPrint(rune(data[i])) {
-					nReadable++
-				}
-			}
+func (f *FileContentFilterOption) SkipReason() string {
+	return f.reason
+}
 
-			return nReadable >= neededPrintable
-		},
-	}
+// WithPrintableContent skips binary files.
+//
+// Deprecated: use WithTextContent.
+func WithPrintableContent() GrepOption {
+	return WithTextContent()
 }
 
+// ContentFilterType only greps files whose content type, as told by
+// http.DetectContentType, contains any of fileTypes.
 func ContentFilterType(fileTypes []string) GrepOption {
 	return &FileContentFilterOption{
 		cb: func(data []byte) bool {
@@ -105,6 +114,49 @@ func ContentFilterType(fileTypes []string) GrepOption {
 			}
 			return true
 		},
+		reason: "content type",
+	}
+}
+
+type FileSizeOption struct {
+	maxSize  int64
+	truncate bool
+}
+
+func (f *FileSizeOption) SkipFile(_ string, info fs.FileInfo) bool {
+	return !f.truncate && info != nil && info.Size() > f.maxSize
+}
+
+func (f *FileSizeOption) SkipFileContent([]byte) bool {
+	return false
+}
+
+func (f *FileSizeOption) SetData(interface{}) {}
+
+func (f *FileSizeOption) SkipReason() string {
+	return "bigger than " + strconv.FormatInt(f.maxSize, 10) + " bytes"
+}
+
+func (f *FileSizeOption) ReadLimit() int64 {
+	if f.truncate {
+		return f.maxSize
+	}
+
+	return 0
+}
+
+// WithMaxFileSize skips files bigger than size bytes.
+func WithMaxFileSize(size int64) GrepOption {
+	return &FileSizeOption{
+		maxSize: size,
+	}
+}
+
+// WithReadLimit only greps the first size bytes of files.
+func WithReadLimit(size int64) GrepOption {
+	return &FileSizeOption{
+		maxSize:  size,
+		truncate: true,
 	}
 }
 
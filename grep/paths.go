@@ -0,0 +1,180 @@
+package grep
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+const (
+	// GitgrepIgnoreFile is the name of the files holding path patterns for
+	// gitgrep to skip, using the same syntax as .gitignore.
+	GitgrepIgnoreFile = ".gitgrepignore"
+	GitIgnoreFile     = ".gitignore"
+)
+
+// PathFilterOption skips files matching gitignore style patterns, like
+// vendor/**, **/*.min.js or !important/**. Patterns come either from the
+// option itself or from ignore files found in the grepped filesystem, in
+// which case they apply to the directory holding the file, like .gitignore
+// files do. When both match a file, the patterns of the option win.
+//
+// Filesystems holding several trees, like the refs of a repository, tell
+// the tree of every file by implementing TreeRooter. Patterns are then
+// matched against the path of files inside their tree.
+type PathFilterOption struct {
+	patterns    []gitignore.Pattern
+	ignoreFiles []string
+
+	fss interface{}
+	// filePatterns are the patterns of the ignore files loaded, by the root
+	// of the tree they belong to
+	filePatterns map[string][]gitignore.Pattern
+	loadedDirs   map[string]bool
+}
+
+// TreeRooter is implemented by filesystems holding several trees, each
+// under its own directory, e.g. refs/heads/main.
+type TreeRooter interface {
+	// TreeRoot returns the directory of the tree the file at name belongs
+	// to, or an empty string if it is not in any.
+	TreeRoot(name string) string
+}
+
+func (f *PathFilterOption) SkipFile(filePath string, _ fs.FileInfo) bool {
+	filePath = strings.TrimPrefix(filePath, "/")
+
+	var root string
+	if rooter, ok := f.fss.(TreeRooter); ok {
+		if root = rooter.TreeRoot(filePath); root != "" {
+			filePath = strings.TrimPrefix(filePath, root+"/")
+		}
+	}
+
+	parts := strings.Split(filePath, "/")
+
+	if result := matchPatterns(f.patterns, parts); result != gitignore.NoMatch {
+		return result == gitignore.Exclude
+	}
+
+	if f.fss == nil || len(f.ignoreFiles) == 0 {
+		return false
+	}
+
+	f.loadIgnoreFiles(root, parts[:len(parts)-1])
+
+	return matchPatterns(f.filePatterns[root], parts) == gitignore.Exclude
+}
+
+func (f *PathFilterOption) SkipFileContent([]byte) bool {
+	return false
+}
+
+func (f *PathFilterOption) SkipReason() string {
+	return "path filter"
+}
+
+// SetData receives the filesystem about to be grepped, to read ignore files
+// from it.
+func (f *PathFilterOption) SetData(data interface{}) {
+	f.fss = data
+	f.filePatterns = make(map[string][]gitignore.Pattern)
+	f.loadedDirs = make(map[string]bool)
+}
+
+// loadIgnoreFiles loads the ignore files of dir, inside the tree at root,
+// and of its parents, if not loaded before. Walks visit parents first, so
+// patterns of deeper directories end up later in the list and take
+// precedence.
+func (f *PathFilterOption) loadIgnoreFiles(root string, dir []string) {
+	for i := 0; i <= len(dir); i++ {
+		domain := dir[:i]
+		key := path.Join(root, path.Join(domain...))
+
+		if f.loadedDirs[key] {
+			continue
+		}
+
+		f.loadedDirs[key] = true
+
+		for _, name := range f.ignoreFiles {
+			content, err := ReadFile(f.fss, path.Join(key, name))
+			if err != nil {
+				continue
+			}
+
+			f.filePatterns[root] = append(f.filePatterns[root], parsePatterns(bytes.NewReader(content), domain)...)
+		}
+	}
+}
+
+// matchPatterns returns the result of the last pattern matching path.
+func matchPatterns(patterns []gitignore.Pattern, path []string) gitignore.MatchResult {
+	for i := len(patterns) - 1; i >= 0; i-- {
+		if result := patterns[i].Match(path, false); result != gitignore.NoMatch {
+			return result
+		}
+	}
+
+	return gitignore.NoMatch
+}
+
+func parsePatterns(r io.Reader, domain []string) []gitignore.Pattern {
+	var patterns []gitignore.Pattern
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		patterns = append(patterns, gitignore.ParsePattern(line, append([]string(nil), domain...)))
+	}
+
+	return patterns
+}
+
+// WithPathPatterns skips files matching any of the given gitignore style
+// patterns. Patterns starting with ! include back files excluded by
+// previous ones.
+func WithPathPatterns(patterns ...string) GrepOption {
+	return WithPathFilter(patterns, nil)
+}
+
+// WithIgnoreFiles skips files matching the patterns of the ignore files with
+// the given names found in the grepped filesystem, such as GitgrepIgnoreFile
+// or GitIgnoreFile.
+func WithIgnoreFiles(names ...string) GrepOption {
+	return WithPathFilter(nil, names)
+}
+
+// WithPathFilter combines WithPathPatterns and WithIgnoreFiles, so patterns
+// can include back files excluded by ignore files.
+func WithPathFilter(patterns []string, ignoreFiles []string) GrepOption {
+	return newPathFilter(patterns, ignoreFiles)
+}
+
+func newPathFilter(patterns []string, ignoreFiles []string) *PathFilterOption {
+	return &PathFilterOption{
+		patterns:    parsePatterns(strings.NewReader(strings.Join(patterns, "\n")), nil),
+		ignoreFiles: ignoreFiles,
+	}
+}
+
+// ReadPathPatterns reads gitignore style patterns from a file in the local
+// filesystem.
+func ReadPathPatterns(filename string) ([]string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(string(content), "\n"), nil
+}
@@ -0,0 +1,74 @@
+package grep
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// rootedFS is a fstest.MapFS holding a tree under every one of roots.
+type rootedFS struct {
+	fstest.MapFS
+	roots []string
+}
+
+func (r rootedFS) TreeRoot(name string) string {
+	for _, root := range r.roots {
+		if strings.HasPrefix(name, root+"/") {
+			return root
+		}
+	}
+
+	return ""
+}
+
+func TestPathFilter(t *testing.T) {
+	files := fstest.MapFS{
+		".gitgrepignore":     {Data: []byte("*.log\n")},
+		"lib/.gitgrepignore": {Data: []byte("# comment\ngen/\n")},
+	}
+
+	refs := rootedFS{
+		MapFS: fstest.MapFS{
+			"refs/heads/main/.gitgrepignore":  {Data: []byte("build/\n")},
+			"refs/heads/other/.gitgrepignore": {Data: []byte("docs/\n")},
+		},
+		roots: []string{"refs/heads/main", "refs/heads/other"},
+	}
+
+	tests := []struct {
+		patterns []string
+		fss      interface{}
+		path     string
+		skip     bool
+	}{
+		{[]string{"vendor/**"}, nil, "vendor/a/b.go", true},
+		{[]string{"vendor/**"}, nil, "/vendor/a/b.go", true},
+		{[]string{"vendor/**"}, nil, "src/vendor/b.go", false},
+		{[]string{"**/*.min.js"}, nil, "web/app.min.js", true},
+		{[]string{"*.go", "!keep.go"}, nil, "keep.go", false},
+		{[]string{"*.go", "!keep.go"}, nil, "main.go", true},
+
+		{nil, files, "app.log", true},
+		{nil, files, "lib/gen/a.go", true},
+		{nil, files, "gen/a.go", false},
+		{[]string{"!app.log"}, files, "app.log", false},
+
+		{[]string{"vendor/**"}, refs, "refs/heads/main/vendor/a.go", true},
+		{[]string{"vendor/**"}, refs, "/refs/heads/other/vendor/a.go", true},
+		{[]string{"vendor/**"}, refs, "refs/heads/main/src/vendor/a.go", false},
+		{nil, refs, "refs/heads/main/build/a.go", true},
+		{nil, refs, "refs/heads/other/build/a.go", false},
+		{nil, refs, "refs/heads/other/docs/a.md", true},
+		{nil, refs, "refs/heads/main/docs/a.md", false},
+	}
+
+	for _, test := range tests {
+		option := WithPathFilter(test.patterns, []string{GitgrepIgnoreFile})
+		option.SetData(test.fss)
+
+		if skip := option.SkipFile(test.path, nil); skip != test.skip {
+			t.Errorf("patterns %q: SkipFile(%q) = %v, want %v", test.patterns, test.path, skip, test.skip)
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package grep
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PEMGrepper finds PEM encoded private keys, reporting the whole block along
+// with the type of the key and whether it is encrypted. Blocks may span lines,
+// or be embedded in strings with escaped newlines. Blocks whose contents do
+// not decode, like the ones in documentation, are not reported.
+type PEMGrepper struct{}
+
+func NewPEMGrepper() *PEMGrepper {
+	return &PEMGrepper{}
+}
+
+var pemBegin = regexp.MustCompile(`-----BEGIN ((?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?)-----`)
+
+// maxPEMBlockSize is the distance from the start of a block within which its
+// end must be found. Files read in chunks have it bounded by the overlap
+// between them, as blocks longer than it would be cut.
+const maxPEMBlockSize = 32 << 10
+
+// pemQuoteEnd and pemQuoteStart are the quotes and concatenation operators
+// around the lines of keys embedded in source code, e.g. "...\n" + or
+// . "...", which are not part of the key.
+var (
+	pemQuoteEnd   = regexp.MustCompile(`["'` + "`" + `]\s*[+.&]?[\s,;)]*$`)
+	pemQuoteStart = regexp.MustCompile(`^\s*[+.&]?\s*["'` + "`" + `]`)
+)
+
+// pemKeyTypes are the types of keys by the label of their blocks.
+var pemKeyTypes = map[string]string{
+	"PRIVATE KEY":           "PKCS#8",
+	"ENCRYPTED PRIVATE KEY": "PKCS#8",
+	"RSA PRIVATE KEY":       "RSA",
+	"DSA PRIVATE KEY":       "DSA",
+	"EC PRIVATE KEY":        "EC",
+	"OPENSSH PRIVATE KEY":   "OpenSSH",
+	"PGP PRIVATE KEY BLOCK": "PGP",
+}
+
+// pemKey is a private key found in a PEM block.
+type pemKey struct {
+	keyType   string
+	encrypted bool
+}
+
+// parsePEMKey parses the body of a block labelled label, between its BEGIN and
+// END lines.
+func parsePEMKey(label string, body []byte) (pemKey, bool) {
+	key := pemKey{keyType: label, encrypted: strings.HasPrefix(label, "ENCRYPTED ")}
+	if t, ok := pemKeyTypes[label]; ok {
+		key.keyType = t
+	}
+
+	// keys embedded in strings have their newlines escaped
+	text := strings.NewReplacer(`\r`, "", `\n`, "\n").Replace(string(body))
+
+	var encoded strings.Builder
+
+	for _, line := range strings.Split(text, "\n") {
+		line = pemQuoteEnd.ReplaceAllString(line, "")
+		line = pemQuoteStart.ReplaceAllString(line, "")
+		line = strings.Trim(line, " \t\r\"',;")
+
+		switch {
+		case strings.Trim(line, "+.&") == "":
+			// concatenation operators left between quoted lines
+		case strings.Contains(line, ":"):
+			// RFC 1421 and armor headers
+			if strings.HasPrefix(line, "Proc-Type:") && strings.Contains(line, "ENCRYPTED") {
+				key.encrypted = true
+			}
+		case label == "PGP PRIVATE KEY BLOCK" && line[0] == '=':
+			// armor checksum
+		default:
+			encoded.WriteString(line)
+		}
+	}
+
+	if encoded.Len() < 64 {
+		return key, false
+	}
+
+	der, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return key, false
+	}
+
+	switch label {
+	case "PRIVATE KEY":
+		key.keyType += pkcs8Algorithm(der)
+	case "OPENSSH PRIVATE KEY":
+		key.encrypted = openSSHEncrypted(der)
+	case "PGP PRIVATE KEY BLOCK":
+		key.encrypted = pgpEncrypted(der)
+	}
+
+	return key, true
+}
+
+// pkcs8Algorithm returns the algorithm of a PKCS#8 key, if known.
+func pkcs8Algorithm(der []byte) string {
+	k, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return ""
+	}
+
+	switch k.(type) {
+	case *rsa.PrivateKey:
+		return " RSA"
+	case *ecdsa.PrivateKey:
+		return " ECDSA"
+	case ed25519.PrivateKey:
+		return " Ed25519"
+	}
+
+	return ""
+}
+
+// openSSHEncrypted tells whether an OpenSSH key is protected with a
+// passphrase, which is the case when it names a cipher.
+func openSSHEncrypted(der []byte) bool {
+	const magic = "openssh-key-v1\x00"
+
+	if !bytes.HasPrefix(der, []byte(magic)) || len(der) < len(magic)+4 {
+		return false
+	}
+
+	rest := der[len(magic):]
+	n := int(binary.BigEndian.Uint32(rest))
+	if len(rest) < 4+n {
+		return false
+	}
+
+	return string(rest[4:4+n]) != "none"
+}
+
+// pgpEncrypted tells whether the first secret key packet of a PGP key is
+// protected with a passphrase.
+func pgpEncrypted(der []byte) bool {
+	if len(der) < 2 || der[0]&0x80 == 0 {
+		return false
+	}
+
+	var tag byte
+	var packet []byte
+
+	if der[0]&0x40 != 0 {
+		// new format
+		tag = der[0] & 0x3f
+
+		switch l := der[1]; {
+		case l < 192:
+			packet = der[2:]
+		case l < 224 && len(der) >= 3:
+			packet = der[3:]
+		case l == 255 && len(der) >= 6:
+			packet = der[6:]
+		default:
+			return false
+		}
+	} else {
+		tag = (der[0] >> 2) & 0x0f
+
+		n := []int{1, 2, 4, 0}[der[0]&0x03]
+		if len(der) < 1+n {
+			return false
+		}
+
+		packet = der[1+n:]
+	}
+
+	// secret key packets
+	if tag != 5 || len(packet) < 6 {
+		return false
+	}
+
+	version, algorithm := packet[0], packet[5]
+	fields := packet[6:]
+
+	if version == 5 {
+		// the length of the public key material follows
+		if len(fields) < 4 {
+			return false
+		}
+
+		n := int(binary.BigEndian.Uint32(fields))
+		if len(fields) < 4+n+1 {
+			return false
+		}
+
+		return fields[4+n] != 0
+	}
+
+	skipMPIs := func(n int) bool {
+		for ; n > 0; n-- {
+			if len(fields) < 2 {
+				return false
+			}
+
+			size := 2 + (int(binary.BigEndian.Uint16(fields))+7)/8
+			if len(fields) < size {
+				return false
+			}
+
+			fields = fields[size:]
+		}
+
+		return true
+	}
+
+	skipField := func() bool {
+		if len(fields) < 1 || len(fields) < 1+int(fields[0]) {
+			return false
+		}
+
+		fields = fields[1+int(fields[0]):]
+		return true
+	}
+
+	var ok bool
+
+	switch algorithm {
+	case 1, 2, 3: // RSA
+		ok = skipMPIs(2)
+	case 16: // Elgamal
+		ok = skipMPIs(3)
+	case 17: // DSA
+		ok = skipMPIs(4)
+	case 18: // ECDH
+		ok = skipField() && skipMPIs(1) && skipField()
+	case 19, 22: // ECDSA, EdDSA
+		ok = skipField() && skipMPIs(1)
+	}
+
+	// the string-to-key usage is 0 for unprotected keys
+	return ok && len(fields) > 0 && fields[0] != 0
+}
+
+func (g PEMGrepper) Grep(fss interface{}, options ...GrepOption) ([]Result, error) {
+	var results []Result
+
+	// end of the last block found in the current file, so blocks in the
+	// overlap between chunks are not reported twice
+	var reported int64
+
+	allowlists := allowlistsOf(options)
+
+	err := scanFiles(fss, options, func(path string, c *chunk) error {
+		// blocks are not keywords
+		if c.scope == keywordRules {
+			return nil
+		}
+
+		last := &reported
+		if c.decoding != nil {
+			last = new(int64)
+		} else if c.first {
+			*last = 0
+		}
+
+		owned := len(c.data) - c.overlap
+
+		for _, loc := range pemBegin.FindAllSubmatchIndex(c.data, -1) {
+			from := loc[0]
+			if from >= owned || c.offset+int64(from) < *last {
+				continue
+			}
+
+			label := string(c.data[loc[2]:loc[3]])
+			endMarker := []byte("-----END " + label + "-----")
+
+			size := maxPEMBlockSize
+			if !c.last && c.overlap < size {
+				size = c.overlap
+			}
+
+			window := c.data[loc[1]:MinInt(len(c.data), from+size)]
+			end := bytes.Index(window, endMarker)
+			if end == -1 {
+				continue
+			}
+
+			key, ok := parsePEMKey(label, window[:end])
+			if !ok {
+				continue
+			}
+
+			to := loc[1] + end + len(endMarker)
+			*last = c.offset + int64(to)
+
+			if allowlists.allows("", path, string(c.data[from:to]), "") {
+				continue
+			}
+
+			offset, line := c.position(from)
+
+			description := key.keyType + " private key"
+			if key.encrypted {
+				description += ", encrypted"
+			}
+
+			comment := fmt.Sprintf("%s: %s", c.where(path, line), description)
+			if c.decoding != nil {
+				comment += fmt.Sprintf(" (%s)", c.decodingChain())
+			}
+
+			results = append(results, Result{
+				Pattern:   pemBegin.String(),
+				Path:      path,
+				Content:   string(c.data[from:to]),
+				Comment:   comment,
+				Offset:    offset,
+				Line:      line,
+				Decoding:  c.decodingChain(),
+				Location:  c.location,
+				KeyType:   key.keyType,
+				Encrypted: key.encrypted,
+			})
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+func (g PEMGrepper) Release() {}
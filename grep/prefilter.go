@@ -0,0 +1,109 @@
+package grep
+
+import (
+	"bytes"
+	"strings"
+)
+
+// prefilterContextLines are the lines around a word a rule requiring it is
+// matched on.
+const prefilterContextLines = 2
+
+// prefilter finds the words rules require in a single pass, so that rules
+// only run on data holding them, and only their matches near them are
+// reported. Words match regardless of case.
+type prefilter struct {
+	words *ahoCorasick
+	// rules are the rules requiring every word
+	rules [][]int
+	// required tells which rules require words
+	required []bool
+}
+
+// newPrefilter returns the prefilter for the words rules require, or nil
+// if none requires any.
+func newPrefilter(rules []Rule) *prefilter {
+	p := &prefilter{
+		required: make([]bool, len(rules)),
+	}
+
+	var words []string
+	index := make(map[string]int)
+
+	for i, rule := range rules {
+		for _, word := range rule.RequiredWords {
+			word = strings.ToLower(word)
+
+			k, ok := index[word]
+			if !ok {
+				k = len(words)
+				index[word] = k
+				words = append(words, word)
+				p.rules = append(p.rules, nil)
+			}
+
+			p.rules[k] = append(p.rules[k], i)
+			p.required[i] = true
+		}
+	}
+
+	if len(words) == 0 {
+		return nil
+	}
+
+	p.words = newAhoCorasick(words, true)
+
+	return p
+}
+
+// found tells which rules have any of the words they require in data.
+func (p *prefilter) found(data []byte) []bool {
+	found := make([]bool, len(p.required))
+
+	p.words.findAll(data, func(word int, _ int, _ int) {
+		for _, rule := range p.rules[word] {
+			found[rule] = true
+		}
+	})
+
+	return found
+}
+
+// near tells whether a word required by rule is found in the lines around
+// data[from:to].
+func (p *prefilter) near(rule int, data []byte, from int, to int) bool {
+	region := linesAround(data, from, to, prefilterContextLines)
+	found := false
+
+	p.words.findAll(data[region[0]:region[1]], func(word int, _ int, _ int) {
+		for _, r := range p.rules[word] {
+			if r == rule {
+				found = true
+			}
+		}
+	})
+
+	return found
+}
+
+// linesAround returns the region of data made of the lines of data[from:to]
+// and n lines before and after them.
+func linesAround(data []byte, from int, to int, n int) [2]int {
+	start := bytes.LastIndexByte(data[:from], '\n') + 1
+	for i := 0; i < n && start > 0; i++ {
+		start = bytes.LastIndexByte(data[:start-1], '\n') + 1
+	}
+
+	end := to
+	for i := 0; i <= n && end < len(data); i++ {
+		next := bytes.IndexByte(data[end:], '\n')
+		if next == -1 {
+			end = len(data)
+			break
+		}
+
+		end += next + 1
+	}
+
+	return [2]int{start, end}
+}
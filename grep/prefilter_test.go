@@ -0,0 +1,52 @@
+package grep
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRequiredWords(t *testing.T) {
+	hex := Rule{Expression: `\b[0-9a-f]{8}\b`, RequiredWords: []string{"token", "secret"}}
+	anchored := Rule{Expression: `[0-9a-f]{8}$`, RequiredWords: []string{"token"}}
+
+	tests := []struct {
+		name    string
+		rule    Rule
+		content string
+		want    []string
+	}{
+		{"same line", hex, "token = deadbeef\n", []string{"deadbeef"}},
+		{"any case", hex, "SECRET = deadbeef\n", []string{"deadbeef"}},
+		{"lines around", hex, "# token\n\nvalue = deadbeef\n", []string{"deadbeef"}},
+		{"too far", hex, "# token\n\n\nvalue = deadbeef\n", nil},
+		{"missing", hex, "value = deadbeef\n", nil},
+		{"some near", hex, "a = 01234567\n\n\n\n\ntoken = deadbeef\n", []string{"deadbeef"}},
+		// $ only matches at the end of the file, wherever the words are
+		{"end of line", anchored, "token = deadbeef\nnext\n", nil},
+		{"end of file", anchored, "token = deadbeef", []string{"deadbeef"}},
+	}
+
+	for _, test := range tests {
+		grepper, err := NewReGrepperForRules([]Rule{test.rule})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files := fstest.MapFS{"file.txt": {Data: []byte(test.content)}}
+
+		results, err := grepper.Grep(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Content)
+		}
+
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("%s: found %q, want %q", test.name, got, test.want)
+		}
+	}
+}
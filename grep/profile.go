@@ -0,0 +1,127 @@
+package grep
+
+import (
+	"io/fs"
+	"regexp"
+)
+
+// SkipProfile describes files not worth grepping because they are vendored,
+// generated or lock files: findings in them are rarely actionable and
+// drown the rest.
+type SkipProfile struct {
+	// Paths are gitignore style patterns of paths to skip.
+	Paths []string
+	// ContentMarkers are matched against the head of files, skipping those
+	// matching any of them.
+	ContentMarkers []*regexp.Regexp
+}
+
+// skipProfileHeadSize is how much of a file is searched for content markers.
+const skipProfileHeadSize = 4096
+
+var defaultSkipPaths = []string{
+	// vendored dependencies
+	"node_modules/",
+	"bower_components/",
+	"jspm_packages/",
+	"vendor/",
+	"third_party/",
+	"Pods/",
+	".yarn/",
+
+	// build output and bundles
+	"dist/",
+	"*.min.js",
+	"*.min.css",
+	"*.bundle.js",
+	"*.chunk.js",
+	"*.js.map",
+	"*.css.map",
+
+	// lock files
+	"package-lock.json",
+	"npm-shrinkwrap.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"composer.lock",
+	"Gemfile.lock",
+	"Cargo.lock",
+	"poetry.lock",
+	"Pipfile.lock",
+	"go.sum",
+	"packages.lock.json",
+
+	// generated code
+	"*.pb.go",
+	"*_pb2.py",
+	"*.pb.cc",
+	"*.pb.h",
+	"*_generated.go",
+	"*.generated.cs",
+	"*.designer.cs",
+}
+
+var defaultContentMarkers = []*regexp.Regexp{
+	regexp.MustCompile(`(?m)^// Code generated .* DO NOT EDIT\.?$`),
+	regexp.MustCompile(`@generated\b`),
+	regexp.MustCompile(`<auto-generated`),
+	regexp.MustCompile(`(?i)automatically generated by`),
+}
+
+// DefaultSkipProfile returns the built-in profile, which can be extended by
+// appending to its fields.
+func DefaultSkipProfile() SkipProfile {
+	return SkipProfile{
+		Paths:          append([]string(nil), defaultSkipPaths...),
+		ContentMarkers: append([]*regexp.Regexp(nil), defaultContentMarkers...),
+	}
+}
+
+type SkipProfileOption struct {
+	paths   *PathFilterOption
+	markers []*regexp.Regexp
+
+	reason string
+}
+
+func (f *SkipProfileOption) SkipFile(path string, info fs.FileInfo) bool {
+	f.reason = "skip profile: path"
+	return f.paths.SkipFile(path, info)
+}
+
+func (f *SkipProfileOption) SkipFileContent(data []byte) bool {
+	head := data[:MinInt(len(data), skipProfileHeadSize)]
+
+	for _, marker := range f.markers {
+		if marker.Match(head) {
+			f.reason = "skip profile: " + marker.String()
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetData receives the filesystem about to be grepped, for path patterns
+// to be matched inside the trees it may hold.
+func (f *SkipProfileOption) SetData(data interface{}) {
+	f.paths.SetData(data)
+}
+
+func (f *SkipProfileOption) SkipReason() string {
+	return f.reason
+}
+
+// WithSkipProfile skips the files described by profile.
+func WithSkipProfile(profile SkipProfile) GrepOption {
+	return &SkipProfileOption{
+		paths:   newPathFilter(profile.Paths, nil),
+		markers: profile.ContentMarkers,
+	}
+}
+
+// WithDefaultSkipProfile skips vendored, generated and lock files, as
+// described by DefaultSkipProfile.
+func WithDefaultSkipProfile() GrepOption {
+	return WithSkipProfile(DefaultSkipProfile())
+}
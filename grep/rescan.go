@@ -2,58 +2,166 @@ package grep
 
 import (
 	"fmt"
-	"io/fs"
 	"regexp"
 )
 
 type ReGrepper struct {
-	res []*regexp.Regexp
+	// res are the compiled expressions of rules, nil for literal ones
+	res       []*regexp.Regexp
+	rules     []Rule
+	literals  *literalMatcher
+	prefilter *prefilter
 }
 
 func NewReGrepper(matches []*regexp.Regexp) *ReGrepper {
+	rules := make([]Rule, 0, len(matches))
+	for _, m := range matches {
+		rules = append(rules, Rule{Expression: m.String()})
+	}
+
 	return &ReGrepper{
-		res: matches,
+		res:   matches,
+		rules: rules,
 	}
 }
 
-func (g ReGrepper) Grep(fss interface{}, options ...GrepOption) ([]Result, error) {
-	var results []Result
+// NewReGrepperForRules creates a grepper matching every rule on the files
+// it applies to.
+func NewReGrepperForRules(rules []Rule) (*ReGrepper, error) {
+	res := make([]*regexp.Regexp, 0, len(rules))
 
-	err := Walk(fss, func(path string, info fs.FileInfo, cberr error) error {
-		if cberr != nil {
-			return cberr
+	for _, rule := range rules {
+		if rule.Flags&Literal != 0 {
+			res = append(res, nil)
+			continue
 		}
 
-		if info.IsDir() {
-			return nil
+		r, err := regexp.Compile(rule.goExpression())
+		if err != nil {
+			return nil, fmt.Errorf("expression '%s' is not valid: %s", rule.Expression, err)
 		}
 
-		for _, option := range options {
-			if option.SkipFile(path) {
-				return nil
+		res = append(res, r)
+	}
+
+	return &ReGrepper{
+		res:       res,
+		rules:     rules,
+		literals:  newLiteralMatcher(rules),
+		prefilter: newPrefilter(rules),
+	}, nil
+}
+
+func (g ReGrepper) Grep(fss interface{}, options ...GrepOption) ([]Result, error) {
+	var results []Result
+
+	// end of the last match of every expression in the current file, so
+	// matches in the overlap between chunks are not reported twice
+	reported := make([]int64, len(g.res))
+
+	allowlists := allowlistsOf(options)
+
+	err := scanFiles(fss, options, func(path string, c *chunk) error {
+		reported := reported
+		if c.decoding != nil {
+			reported = make([]int64, len(g.res))
+		} else if c.first {
+			for i := range reported {
+				reported[i] = 0
 			}
 		}
 
-		content, err := ReadFile(fss, path)
-		if err != nil {
-			return err
+		// matches starting in the overlap with the next chunk are left to
+		// it, where they can be found whole
+		owned := len(c.data) - c.overlap
+
+		// literals are all found in a single pass
+		var literalMatches map[int][][]int
+		if g.literals != nil {
+			literalMatches = g.literals.findAll(c.data)
 		}
 
-		for _, option := range options {
-			if option.SkipFileContent(content) {
-				return nil
-			}
+		// rules requiring words only run where they are found
+		var found []bool
+		if g.prefilter != nil {
+			found = g.prefilter.found(c.data)
 		}
 
-		for _, m := range g.res {
-			findings := m.FindAll(content, -1)
+		for i, m := range g.res {
+			if !g.rules[i].Applies(path) || !c.matches(g.rules[i]) {
+				continue
+			}
+
+			required := g.prefilter != nil && g.prefilter.required[i]
+			if required && !found[i] {
+				continue
+			}
+
+			locs := literalMatches[i]
+			if m != nil {
+				locs = m.FindAllIndex(c.data, -1)
+			}
+
+			// matches of rules requiring words are confirmed by finding them
+			// near the match, as hyperscan does
+			if required {
+				var near [][]int
+				for _, loc := range locs {
+					if g.prefilter.near(i, c.data, loc[0], loc[1]) {
+						near = append(near, loc)
+					}
+				}
+
+				locs = near
+			}
+
+			for _, loc := range locs {
+				from, to := loc[0], loc[1]
+
+				if c.offset+int64(from) < reported[i] || from >= owned {
+					continue
+				}
+
+				reported[i] = c.offset + int64(to)
+
+				// keyword matches are only worth reporting along with the
+				// value assigned to the keyword
+				var secret string
+				if g.rules[i].Keyword != "" {
+					var ok bool
+					if secret, ok = assignedValue(c.data, to, !c.last); !ok {
+						continue
+					}
+				}
+
+				f := c.data[from:to]
+				if allowlists.allows(g.rules[i].Allowlist, path, string(f), secret) {
+					continue
+				}
+
+				offset, line := c.position(from)
+
+				comment := fmt.Sprintf("%s: %s", c.where(path, line), f)
+				if secret != "" {
+					comment += fmt.Sprintf(" => %q", secret)
+				}
+				if c.decoding != nil {
+					comment += fmt.Sprintf(" (%s)", c.decodingChain())
+				}
 
-			for _, f := range findings {
 				results = append(results, Result{
-					Pattern: m.String(),
-					Path:    path,
-					Content: string(f),
-					Comment: fmt.Sprintf("%s: %s", path, f),
+					PatternID: uint(i),
+					Pattern:   g.rules[i].Expression,
+					Path:      path,
+					Content:   string(f),
+					Comment:   comment,
+					Offset:    offset,
+					Line:      line,
+					Decoding:  c.decodingChain(),
+					Location:  c.location,
+					Keyword:   g.rules[i].Keyword,
+					Part:      g.rules[i].Part,
+					Secret:    secret,
 				})
 			}
 		}
@@ -61,7 +169,7 @@ func (g ReGrepper) Grep(fss interface{}, options ...GrepOption) ([]Result, error
 		return nil
 	})
 
-	return results, err
+	return firstMatches(results, g.rules), err
 }
 
 func (g ReGrepper) Release() {}
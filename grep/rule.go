@@ -0,0 +1,323 @@
+package grep
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rule is an expression to grep for, which may only apply to some files.
+type Rule struct {
+	Expression string
+	// Extensions limits the rule to files with any of them, if not empty.
+	Extensions []string
+	// ExcludedExtensions are the extensions of files the rule does not apply
+	// to.
+	ExcludedExtensions []string
+	// Keyword is the keyword the rule was expanded from, if any.
+	Keyword string
+	Flags   RuleFlags
+	// RequiredWords are the words, any of which must be found near a match
+	// of the rule for it to be reported, regardless of case. Rules requiring
+	// words are only run on data holding any of them.
+	RequiredWords []string
+	// Part is the composite rule the rule is a part of, if any.
+	Part *CompositePart
+	// Allowlist is the name of the allowlist dropping matches of the rule,
+	// besides the global one.
+	Allowlist string
+}
+
+// RuleFlags change how the expression of a rule matches, the same way in
+// every grepper.
+type RuleFlags uint
+
+const (
+	// DotAll makes . match newlines too.
+	DotAll RuleFlags = 1 << iota
+	// MultiLine makes ^ and $ match at the start and end of every line,
+	// instead of only at the start and end of the file.
+	MultiLine
+	// Caseless matches regardless of case.
+	Caseless
+	// SingleMatch only reports the first match of the rule in every file.
+	SingleMatch
+	// Literal makes the expression a fixed string instead of a regexp.
+	Literal
+)
+
+// ruleFlagLetters are the letters setting flags in /expression/flags, the
+// same hyperscan uses, and F for literals like in grep.
+var ruleFlagLetters = map[rune]RuleFlags{
+	's': DotAll,
+	'm': MultiLine,
+	'i': Caseless,
+	'H': SingleMatch,
+	'F': Literal,
+}
+
+// ParseRule parses a rule written as an expression, or as
+// /expression/flags:words@allowlist to set flags, the comma separated
+// words it requires and the allowlist it uses, e.g. /api_key/iH or
+// /[0-9a-f]{32}/:secret,token@hex. Values whose last / is not followed by
+// flags, like /etc/passwd, are plain expressions.
+func ParseRule(value string) (Rule, error) {
+	end := strings.LastIndexByte(value, '/')
+	if !strings.HasPrefix(value, "/") || end < 1 {
+		return Rule{Expression: value}, nil
+	}
+
+	rule := Rule{Expression: value[1:end]}
+
+	flags := value[end+1:]
+	if i := strings.IndexByte(flags, '@'); i != -1 {
+		rule.Allowlist = strings.TrimSpace(flags[i+1:])
+		flags = flags[:i]
+	}
+
+	if i := strings.IndexByte(flags, ':'); i != -1 {
+		for _, word := range strings.Split(flags[i+1:], ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				rule.RequiredWords = append(rule.RequiredWords, word)
+			}
+		}
+
+		flags = flags[:i]
+	}
+
+	for _, letter := range flags {
+		flag, ok := ruleFlagLetters[letter]
+		if !ok {
+			return Rule{Expression: value}, nil
+		}
+
+		rule.Flags |= flag
+	}
+
+	return rule, nil
+}
+
+// goExpression returns the expression of the rule for the regexp package,
+// with its flags set.
+func (r Rule) goExpression() string {
+	expression := r.Expression
+	if r.Flags&Literal != 0 {
+		expression = regexp.QuoteMeta(expression)
+	}
+
+	var flags string
+
+	if r.Flags&DotAll != 0 {
+		flags += "s"
+	}
+
+	if r.Flags&MultiLine != 0 {
+		flags += "m"
+	}
+
+	if r.Flags&Caseless != 0 {
+		flags += "i"
+	}
+
+	if flags == "" {
+		return expression
+	}
+
+	return "(?" + flags + ")" + expression
+}
+
+// Applies tells whether the rule applies to the file at path.
+func (r Rule) Applies(filePath string) bool {
+	if len(r.Extensions) == 0 && len(r.ExcludedExtensions) == 0 {
+		return true
+	}
+
+	ext := strings.ToLower(path.Ext(filePath))
+
+	for _, e := range r.ExcludedExtensions {
+		if ext == e {
+			return false
+		}
+	}
+
+	if len(r.Extensions) == 0 {
+		return true
+	}
+
+	for _, e := range r.Extensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RulesOf returns rules applying to every file for expressions.
+func RulesOf(expressions []string) []Rule {
+	rules := make([]Rule, 0, len(expressions))
+
+	for _, e := range expressions {
+		rules = append(rules, Rule{Expression: e})
+	}
+
+	return rules
+}
+
+// TemplateSet is the set of assignment templates for a type of file.
+type TemplateSet struct {
+	Name       string
+	Extensions []string
+	Templates  []string
+}
+
+// Templates are the assignment shapes keywords are expanded into, by file
+// type. The set without extensions applies to files no other set applies
+// to.
+type Templates []TemplateSet
+
+// KeywordPlaceholder is replaced by the keyword in templates.
+const KeywordPlaceholder = "{KEY}"
+
+//go:embed templates.txt
+var defaultTemplates string
+
+// DefaultTemplates returns the built-in templates.
+func DefaultTemplates() Templates {
+	templates, err := ParseTemplates(strings.NewReader(defaultTemplates))
+	if err != nil {
+		panic(err)
+	}
+
+	return templates
+}
+
+// ParseTemplates reads templates in the format of templates.txt: sections
+// headed by [name .ext .ext] followed by a template per line. Empty lines
+// and lines starting with # are ignored.
+func ParseTemplates(r io.Reader) (Templates, error) {
+	var templates Templates
+
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			fields := strings.Fields(line[1 : len(line)-1])
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("line %d: section without a name", n)
+			}
+
+			set := TemplateSet{Name: fields[0]}
+			for _, ext := range fields[1:] {
+				set.Extensions = append(set.Extensions, strings.ToLower(ext))
+			}
+
+			templates = append(templates, set)
+			continue
+		}
+
+		if len(templates) == 0 {
+			return nil, fmt.Errorf("line %d: template outside of a section", n)
+		}
+
+		if !strings.Contains(line, KeywordPlaceholder) {
+			return nil, fmt.Errorf("line %d: template without %s", n, KeywordPlaceholder)
+		}
+
+		set := &templates[len(templates)-1]
+		set.Templates = append(set.Templates, line)
+	}
+
+	return templates, scanner.Err()
+}
+
+// ReadTemplates reads templates from a file.
+func ReadTemplates(filename string) (Templates, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return ParseTemplates(fd)
+}
+
+// Expand returns the rules finding assignments to keyword in every type of
+// file. keyword is an expression, so it is used as it is.
+func (t Templates) Expand(keyword string) []Rule {
+	var claimed []string
+
+	for _, set := range t {
+		claimed = append(claimed, set.Extensions...)
+	}
+
+	var rules []Rule
+
+	for _, set := range t {
+		var excluded []string
+		if len(set.Extensions) == 0 {
+			excluded = claimed
+		}
+
+		for _, template := range set.Templates {
+			rules = append(rules, Rule{
+				Expression:         strings.Replace(template, KeywordPlaceholder, keyword, -1),
+				Extensions:         set.Extensions,
+				ExcludedExtensions: excluded,
+				Keyword:            keyword,
+			})
+		}
+	}
+
+	return rules
+}
+
+// firstMatches drops the results of rules with the SingleMatch flag but the
+// first one found in every file. Results tell their rule by PatternID.
+func firstMatches(results []Result, rules []Rule) []Result {
+	type fileRule struct {
+		path string
+		id   uint
+	}
+
+	first := make(map[fileRule]int)
+
+	for i, result := range results {
+		if rules[result.PatternID].Flags&SingleMatch == 0 {
+			continue
+		}
+
+		key := fileRule{path: result.Path, id: result.PatternID}
+		if j, ok := first[key]; !ok || result.Offset < results[j].Offset {
+			first[key] = i
+		}
+	}
+
+	if len(first) == 0 {
+		return results
+	}
+
+	var tmp []Result
+
+	for i, result := range results {
+		if rules[result.PatternID].Flags&SingleMatch != 0 && first[fileRule{path: result.Path, id: result.PatternID}] != i {
+			continue
+		}
+
+		tmp = append(tmp, result)
+	}
+
+	return tmp
+}
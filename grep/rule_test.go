@@ -0,0 +1,39 @@
+package grep
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Rule
+	}{
+		{`api_key`, Rule{Expression: `api_key`}},
+		{`/api_key/iH`, Rule{Expression: `api_key`, Flags: Caseless | SingleMatch}},
+		{`/a.b/F`, Rule{Expression: `a.b`, Flags: Literal}},
+		{`/[0-9a-f]{32}/:secret, token@hex`, Rule{Expression: `[0-9a-f]{32}`, RequiredWords: []string{"secret", "token"}, Allowlist: "hex"}},
+		{`/x/s@`, Rule{Expression: `x`, Flags: DotAll}},
+		{`/a/b/m`, Rule{Expression: `a/b`, Flags: MultiLine}},
+
+		// paths are not flags
+		{`/etc/passwd`, Rule{Expression: `/etc/passwd`}},
+		{`/api/v1/token`, Rule{Expression: `/api/v1/token`}},
+		{`/api/v1/token:x`, Rule{Expression: `/api/v1/token:x`}},
+		{`/`, Rule{Expression: `/`}},
+	}
+
+	for _, test := range tests {
+		rule, err := ParseRule(test.value)
+		if err != nil {
+			t.Errorf("%q: %s", test.value, err)
+			continue
+		}
+
+		if rule.Expression != test.want.Expression || rule.Flags != test.want.Flags || rule.Allowlist != test.want.Allowlist ||
+			strings.Join(rule.RequiredWords, ",") != strings.Join(test.want.RequiredWords, ",") {
+			t.Errorf("%q: parsed as %+v, want %+v", test.value, rule, test.want)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package grep
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"sync"
+	"syscall"
+)
+
+// ScanStats counts what a Grep call did. Pass WithStats to a Grep call to
+// have them collected.
+type ScanStats struct {
+	lock sync.Mutex
+
+	FilesScanned    int
+	FilesSkipped    int
+	FilesTruncated  int
+	FilesChunked    int
+	FilesTranscoded int
+	BytesScanned    int64
+	ArchivesOpened  int
+
+	Skipped []SkippedFile
+}
+
+// SkippedFile is a file left out of a scan, and why.
+type SkippedFile struct {
+	Path   string
+	Reason string
+}
+
+func (s *ScanStats) scanned(size int64, truncated bool, chunked bool, transcoded bool) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.FilesScanned++
+	s.BytesScanned += size
+
+	if truncated {
+		s.FilesTruncated++
+	}
+
+	if chunked {
+		s.FilesChunked++
+	}
+
+	if transcoded {
+		s.FilesTranscoded++
+	}
+}
+
+func (s *ScanStats) skipped(path string, reason string) {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.FilesSkipped++
+	s.Skipped = append(s.Skipped, SkippedFile{Path: path, Reason: reason})
+}
+
+func (s *ScanStats) archive() {
+	if s == nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.ArchivesOpened++
+}
+
+// SkippedByReason counts skipped files by the reason they were skipped for.
+func (s *ScanStats) SkippedByReason() map[string]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	counts := make(map[string]int)
+	for _, skipped := range s.Skipped {
+		counts[skipped.Reason]++
+	}
+
+	return counts
+}
+
+// skipReason tells why option skipped the last file.
+func skipReason(option GrepOption) string {
+	if o, ok := option.(SkipReasoner); ok {
+		if reason := o.SkipReason(); reason != "" {
+			return reason
+		}
+	}
+
+	return fmt.Sprintf("%T", option)
+}
+
+type StatsOption struct {
+	stats *ScanStats
+}
+
+func (o *StatsOption) SkipFile(string, fs.FileInfo) bool {
+	return false
+}
+
+func (o *StatsOption) SkipFileContent([]byte) bool {
+	return false
+}
+
+func (o *StatsOption) SetData(interface{}) {}
+
+// WithStats collects the statistics of a Grep call into stats.
+func WithStats(stats *ScanStats) GrepOption {
+	return &StatsOption{
+		stats: stats,
+	}
+}
+
+// readLimiter is implemented by options limiting how much of each file is
+// read.
+type readLimiter interface {
+	ReadLimit() int64
+}
+
+// scanner holds the settings of a scanFiles call, taken from its options.
+type scanner struct {
+	options []GrepOption
+	scan    func(path string, c *chunk) error
+
+	stats     *ScanStats
+	limit     int64
+	chunkSize int
+	overlap   int
+	useMmap   bool
+	archives  *ArchiveOption
+	extract   *ExtractOption
+}
+
+// scanFiles walks fss, calling scan with the path and content of every file
+// not skipped by options. Files bigger than the chunk size are read and
+// handed to scan in chunks, in order; the rest are handed whole.
+func scanFiles(fss interface{}, options []GrepOption, scan func(path string, c *chunk) error) error {
+	s := &scanner{
+		options:   options,
+		scan:      scan,
+		chunkSize: defaultChunkSize,
+		overlap:   defaultChunkOverlap,
+		useMmap:   true,
+	}
+
+	for _, option := range options {
+		option.SetData(fss)
+
+		switch o := option.(type) {
+		case *StatsOption:
+			s.stats = o.stats
+		case *MmapOption:
+			s.useMmap = false
+		case *ArchiveOption:
+			s.archives = o
+		case *ExtractOption:
+			s.extract = o
+		case *DecodeOption:
+			s.scan = func(path string, c *chunk) error {
+				if err := scan(path, c); err != nil {
+					return err
+				}
+
+				return o.decodedChunks(c, func(dc *chunk) error {
+					return scan(path, dc)
+				})
+			}
+		}
+
+		if o, ok := option.(readLimiter); ok && o.ReadLimit() > 0 {
+			if s.limit == 0 || o.ReadLimit() < s.limit {
+				s.limit = o.ReadLimit()
+			}
+		}
+
+		if o, ok := option.(chunker); ok {
+			s.chunkSize, s.overlap = o.ChunkSize()
+		}
+	}
+
+	return s.walk(fss, "", 0)
+}
+
+// walk scans the files of fss, which is an archive nested depth levels deep
+// when prefix is not empty.
+func (s *scanner) walk(fss interface{}, prefix string, depth int) error {
+	return Walk(fss, func(path string, info fs.FileInfo, cberr error) error {
+		if cberr != nil {
+			return cberr
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		return s.scanFile(fss, path, prefix+path, info, depth)
+	})
+}
+
+func (s *scanner) skipContent(name string, content []byte) bool {
+	for _, option := range s.options {
+		if option.SkipFileContent(content) {
+			s.stats.skipped(name, skipReason(option))
+			return true
+		}
+	}
+
+	return false
+}
+
+// skipFile tells whether the options skip the file at name. Archives are
+// not skipped for their extension, as the files inside them may have one of
+// those grepped.
+func (s *scanner) skipFile(name string, info fs.FileInfo, archive bool) bool {
+	for _, option := range s.options {
+		if e, ok := option.(*ExtensionFilterOption); ok && e.inverse && archive {
+			continue
+		}
+
+		if option.SkipFile(name, info) {
+			s.stats.skipped(name, skipReason(option))
+			return true
+		}
+	}
+
+	return false
+}
+
+// scanFile scans the file at path of fss, reported as name.
+func (s *scanner) scanFile(fss interface{}, path string, name string, info fs.FileInfo, depth int) error {
+	archive := s.isArchive(name, info, depth)
+
+	if s.skipFile(name, info, archive) {
+		return nil
+	}
+
+	// mapped files are not loaded in memory, but big ones are still scanned
+	// in chunks, so matches are found the same way as in read files
+	var data []byte
+	if s.useMmap && !archive {
+		data = mmapFile(fss, path, info)
+	}
+
+	if data != nil {
+		defer syscall.Munmap(data)
+
+		truncated := s.limit > 0 && info.Size() > s.limit
+		if truncated {
+			data = data[:s.limit]
+		}
+
+		if len(data) > s.chunkSize {
+			return s.scanChunked(name, data[:s.chunkSize], bytes.NewReader(data[s.chunkSize:]), truncated)
+		}
+
+		return s.scanWhole(name, data, truncated)
+	}
+
+	fd, err := OpenFile(fss, path)
+	if err != nil {
+		s.stats.skipped(name, "unreadable: "+err.Error())
+		return nil
+	}
+
+	defer fd.Close()
+
+	return s.scanStream(name, info, fd, archive, depth)
+}
+
+// scanEntry scans a file of an archive nested depth levels deep, reported
+// as name, as it is read from r.
+func (s *scanner) scanEntry(name string, info fs.FileInfo, r io.Reader, depth int) error {
+	archive := s.isArchive(name, info, depth)
+
+	if s.skipFile(name, info, archive) {
+		return nil
+	}
+
+	return s.scanStream(name, info, r, archive, depth)
+}
+
+// scanStream scans a file not skipped by the options, reported as name, as
+// it is read from r. Its size is -1 if not known.
+func (s *scanner) scanStream(name string, info fs.FileInfo, r io.Reader, archive bool, depth int) error {
+	if archive {
+		rest, ok, err := s.scanArchive(name, r, info.Size(), depth)
+		if ok {
+			return err
+		}
+
+		// archives that cannot be opened are scanned as regular files
+		if s.skipFile(name, info, false) {
+			return nil
+		}
+
+		r = rest
+	}
+
+	size := info.Size()
+
+	truncated := s.limit > 0 && size > s.limit
+	if s.limit > 0 {
+		r = io.LimitReader(r, s.limit)
+	}
+
+	if truncated {
+		size = s.limit
+	}
+
+	// files of unknown size are chunked once they fill the first chunk
+	chunked := size > int64(s.chunkSize)
+
+	var head []byte
+	var err error
+
+	switch {
+	case chunked:
+		head = make([]byte, s.chunkSize)
+
+		var n int
+		n, err = io.ReadFull(r, head)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+
+		head = head[:n]
+	case size < 0:
+		head, err = ioutil.ReadAll(io.LimitReader(r, int64(s.chunkSize)))
+		chunked = len(head) == s.chunkSize
+	default:
+		head, err = ioutil.ReadAll(r)
+	}
+
+	if err != nil {
+		s.stats.skipped(name, "unreadable: "+err.Error())
+		return nil
+	}
+
+	if !chunked {
+		return s.scanWhole(name, head, truncated)
+	}
+
+	return s.scanChunked(name, head, r, truncated)
+}
+
+// scanChunked scans a file in chunks, the first of which is head and the
+// rest read from r, transcoding them first if it is UTF-16.
+func (s *scanner) scanChunked(name string, head []byte, r io.Reader, truncated bool) error {
+	utf16 := detectUTF16(head)
+	if utf16 != nil {
+		r = &utf16Reader{r: io.MultiReader(bytes.NewReader(head[utf16.bom:]), r), order: utf16.order}
+		head = make([]byte, s.chunkSize)
+
+		n, err := io.ReadFull(r, head)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			s.stats.skipped(name, "unreadable: "+err.Error())
+			return nil
+		}
+
+		head = head[:n]
+	}
+
+	if s.skipContent(name, head) {
+		return nil
+	}
+
+	read, err := readChunks(head, r, s.chunkSize, s.overlap, utf16, func(c *chunk) error {
+		return s.scan(name, c)
+	})
+
+	s.stats.scanned(read, truncated, true, utf16 != nil)
+
+	return err
+}
+
+// scanWhole scans a file read whole, transcoding it first if it is UTF-16.
+func (s *scanner) scanWhole(name string, data []byte, truncated bool) error {
+	c := &chunk{
+		data:  data,
+		line:  1,
+		first: true,
+		last:  true,
+	}
+
+	if utf16 := detectUTF16(data); utf16 != nil {
+		c.data = utf16.transcode(data)
+		c.transcoded = true
+		c.rawOffset = int64(utf16.bom)
+	}
+
+	if s.skipContent(name, c.data) {
+		return nil
+	}
+
+	s.stats.scanned(int64(len(c.data)), truncated, false, c.transcoded)
+
+	if s.extract != nil {
+		if e := s.extract.extractor(name); e != nil {
+			if units, err := e.Extract(c.data); err == nil {
+				c.scope = keywordRules
+				if err := s.scan(name, c); err != nil {
+					return err
+				}
+
+				return s.scanUnits(name, c, units)
+			}
+		}
+	}
+
+	return s.scan(name, c)
+}
+
+// scanUnits scans the units extracted from the file read into c.
+func (s *scanner) scanUnits(name string, c *chunk, units []Unit) error {
+	for _, unit := range units {
+		offset, line := c.position(int(unit.Offset))
+
+		err := s.scan(name, &chunk{
+			data:     unit.Text,
+			line:     1,
+			first:    true,
+			last:     true,
+			origin:   &position{offset: offset, line: line},
+			location: unit.Location,
+			scope:    nonKeywordRules,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,252 @@
+package grep
+
+import (
+	"math"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Penalties applied to the confidence of a match for every heuristic it
+// trips. They multiply, so a match tripping several drops quickly.
+const (
+	placeholderPenalty = 0.1
+	repeatedPenalty    = 0.3
+	sequencePenalty    = 0.3
+	entropyPenalty     = 0.5
+	testPathPenalty    = 0.4
+)
+
+// templatedValue are values filled in by templating, not secrets.
+var templatedValue = regexp.MustCompile(`<[^<>]+>|\{\{[^{}]*\}\}|\$\{[^{}]*\}|%\([^()]*\)s`)
+
+// testDirectories hold tests, fixtures and examples.
+var testDirectories = map[string]bool{
+	"test": true, "tests": true, "testdata": true, "testing": true,
+	"__tests__": true, "spec": true, "specs": true, "fixtures": true,
+	"fixture": true, "mocks": true, "mock": true, "examples": true,
+	"example": true, "samples": true, "sample": true,
+}
+
+// ScoringGrepper scores the matches of another grepper on how likely they are
+// to be real secrets, and drops those below a minimum confidence. Matches of
+// placeholders, repeated or sequential characters, low entropy values and
+// files of tests or examples are scored lower, the reasons being attached to
+// them.
+type ScoringGrepper struct {
+	grepper       Grepper
+	minConfidence float64
+}
+
+// NewScoringGrepper scores the matches of grepper, dropping those scored below
+// minConfidence. A minConfidence of 0 keeps every match.
+func NewScoringGrepper(grepper Grepper, minConfidence float64) *ScoringGrepper {
+	return &ScoringGrepper{
+		grepper:       grepper,
+		minConfidence: minConfidence,
+	}
+}
+
+func (g ScoringGrepper) Grep(fss interface{}, options ...GrepOption) ([]Result, error) {
+	found, err := g.grepper.Grep(fss, options...)
+
+	var results []Result
+
+	// paths are scored inside their tree, whose root may look like a test
+	// directory, e.g. refs/heads/test
+	rooter, _ := fss.(TreeRooter)
+
+	for _, result := range found {
+		scored := result
+		if rooter != nil {
+			if root := rooter.TreeRoot(result.Path); root != "" {
+				scored.Path = strings.TrimPrefix(strings.TrimPrefix(result.Path, "/"), root+"/")
+			}
+		}
+
+		result.Confidence, result.Reasons = Score(scored)
+
+		if result.Confidence < g.minConfidence {
+			continue
+		}
+
+		results = append(results, result)
+	}
+
+	return results, err
+}
+
+func (g ScoringGrepper) Release() {
+	g.grepper.Release()
+}
+
+// Score returns how likely result is a real secret, from 0 to 1, and the
+// reasons it is not 1.
+func Score(result Result) (float64, []string) {
+	confidence := 1.0
+	var reasons []string
+
+	lower := func(penalty float64, reason string) {
+		confidence *= penalty
+		reasons = append(reasons, reason)
+	}
+
+	// the value assigned is what tells keyword matches apart, the keyword
+	// being matched either way
+	value := result.Secret
+	if value == "" {
+		value = result.Content
+	}
+
+	// private keys are multiline blocks scored on their own
+	if result.KeyType == "" {
+		if word, ok := placeholder(value); ok {
+			lower(placeholderPenalty, "placeholder "+word)
+		}
+
+		if run := longestRun(value); run >= 6 {
+			lower(repeatedPenalty, "repeated characters")
+		}
+
+		if run := longestSequence(value); run >= 6 {
+			lower(sequencePenalty, "sequential characters")
+		}
+
+		if len(value) >= 8 {
+			if e, min := entropy(value), minEntropy(len(value)); e < min {
+				lower(entropyPenalty, "low entropy")
+			}
+		}
+	}
+
+	if testPath(result.Path) {
+		lower(testPathPenalty, "test or example path")
+	}
+
+	return confidence, reasons
+}
+
+// placeholder returns the placeholder word or templating found in value, if
+// any.
+func placeholder(value string) (string, bool) {
+	if placeholderValue.MatchString(value) {
+		return value, true
+	}
+
+	if m := templatedValue.FindString(value); m != "" {
+		return m, true
+	}
+
+	lower := strings.ToLower(value)
+	for _, word := range placeholderWords {
+		if strings.Contains(lower, word) {
+			return word, true
+		}
+	}
+
+	return "", false
+}
+
+// longestRun returns the length of the longest run of a repeated byte in s.
+func longestRun(s string) int {
+	longest, run := 0, 0
+
+	for i := 0; i < len(s); i++ {
+		if i > 0 && s[i] == s[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+
+		if run > longest {
+			longest = run
+		}
+	}
+
+	return longest
+}
+
+// longestSequence returns the length of the longest run of consecutive
+// ascending or descending bytes in s, e.g. 123456 or abcdef.
+func longestSequence(s string) int {
+	longest, run, step := 0, 0, 0
+
+	for i := 0; i < len(s); i++ {
+		d := 0
+		if i > 0 {
+			d = int(s[i]) - int(s[i-1])
+		}
+
+		switch {
+		case (d == 1 || d == -1) && d == step:
+			run++
+		case d == 1 || d == -1:
+			run, step = 2, d
+		default:
+			run, step = 1, 0
+		}
+
+		if run > longest {
+			longest = run
+		}
+	}
+
+	return longest
+}
+
+// entropy returns the Shannon entropy of s, in bits per byte.
+func entropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+
+	e := 0.0
+	for _, n := range counts {
+		if n == 0 {
+			continue
+		}
+
+		p := float64(n) / float64(len(s))
+		e -= p * math.Log2(p)
+	}
+
+	return e
+}
+
+// minEntropy is the entropy below which a value of length n is not random
+// enough to be a secret. Short values cannot reach the entropy of long ones,
+// so it grows with the length, up to that of 64 bytes.
+func minEntropy(n int) float64 {
+	if n > 64 {
+		n = 64
+	}
+
+	return 0.6 * math.Log2(float64(n))
+}
+
+// testPath tells whether the file at p holds tests, fixtures or examples.
+func testPath(p string) bool {
+	p = strings.ToLower(p)
+
+	for _, dir := range strings.Split(path.Dir(p), "/") {
+		if testDirectories[dir] {
+			return true
+		}
+	}
+
+	base := path.Base(p)
+	ext := path.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+
+	switch {
+	case strings.HasSuffix(name, "_test"), strings.HasPrefix(name, "test_"):
+		return true
+	case strings.HasSuffix(name, ".test"), strings.HasSuffix(name, ".spec"):
+		return true
+	case ext == ".example", ext == ".sample", ext == ".dist":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,231 @@
+package grep
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// StructuredGrepper parses .env, JSON, YAML, INI and properties files and
+// matches rules against their keys and values, instead of their raw text.
+// Keyword rules match the path of keys leading to a value, e.g.
+// database.password, and report the value as the secret. Other rules match
+// values. Files in any other format are not grepped.
+type StructuredGrepper struct {
+	keywords  []structuredKeyword
+	values    []structuredValue
+	prefilter *prefilter
+}
+
+type structuredKeyword struct {
+	// id is the index of the first rule the keyword was expanded into.
+	id        uint
+	keyword   string
+	re        *regexp.Regexp
+	allowlist string
+	// rules are the rules the keyword was expanded into, one of which must
+	// apply to a file for the keyword to be matched in it.
+	rules []Rule
+}
+
+// applies tells whether any of the rules of the keyword applies to the file
+// at filePath.
+func (k structuredKeyword) applies(filePath string) bool {
+	for _, rule := range k.rules {
+		if rule.Applies(filePath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type structuredValue struct {
+	id   uint
+	rule Rule
+	re   *regexp.Regexp
+}
+
+// NewStructuredGrepper creates a grepper for rules. Keyword rules expanded
+// into several templates are matched once, in the files any of them applies
+// to. Rules requiring words are only matched on the values whose key path or
+// value hold any of them.
+func NewStructuredGrepper(rules []Rule) (*StructuredGrepper, error) {
+	g := &StructuredGrepper{
+		prefilter: newPrefilter(rules),
+	}
+	seen := make(map[string]int)
+
+	for i, rule := range rules {
+		// parts of composite rules are only reported combined
+		if rule.Part != nil {
+			continue
+		}
+
+		if rule.Keyword == "" {
+			r, err := regexp.Compile(rule.goExpression())
+			if err != nil {
+				return nil, fmt.Errorf("expression '%s' is not valid: %s", rule.Expression, err)
+			}
+
+			g.values = append(g.values, structuredValue{id: uint(i), rule: rule, re: r})
+			continue
+		}
+
+		if i, ok := seen[rule.Keyword]; ok {
+			g.keywords[i].rules = append(g.keywords[i].rules, rule)
+			continue
+		}
+
+		seen[rule.Keyword] = len(g.keywords)
+
+		r, err := regexp.Compile(Rule{Expression: rule.Keyword, Flags: rule.Flags}.goExpression())
+		if err != nil {
+			return nil, fmt.Errorf("keyword '%s' is not valid: %s", rule.Keyword, err)
+		}
+
+		g.keywords = append(g.keywords, structuredKeyword{
+			id:        uint(i),
+			keyword:   rule.Keyword,
+			re:        r,
+			allowlist: rule.Allowlist,
+			rules:     []Rule{rule},
+		})
+	}
+
+	return g, nil
+}
+
+// configFields parses the file called name if it is in one of the formats
+// understood.
+func configFields(name string, content []byte) ([]Field, bool) {
+	var flatten func([]byte) ([]Field, error)
+
+	base := strings.ToLower(path.Base(name))
+
+	switch {
+	case base == ".env" || strings.HasPrefix(base, ".env.") || hasExtension(base, ".env"):
+		flatten = flattenEnv
+	case hasExtension(base, ".json"):
+		flatten = flattenJSON
+	case hasExtension(base, ".yaml", ".yml"):
+		flatten = flattenYAML
+	case hasExtension(base, ".ini", ".cfg", ".conf"):
+		flatten = flattenINI
+	case hasExtension(base, ".properties"):
+		flatten = flattenProperties
+	default:
+		return nil, false
+	}
+
+	fields, err := flatten(content)
+	if err != nil {
+		return nil, false
+	}
+
+	return fields, true
+}
+
+func (g StructuredGrepper) Grep(fss interface{}, options ...GrepOption) ([]Result, error) {
+	var results []Result
+
+	allowlists := allowlistsOf(options)
+
+	// files are parsed whole, not as the units extractors split them into,
+	// and are counted by the greppers used alongside
+	var scanOptions []GrepOption
+	for _, option := range options {
+		switch option.(type) {
+		case *ExtractOption, *DecodeOption, *StatsOption:
+			continue
+		}
+
+		scanOptions = append(scanOptions, option)
+	}
+
+	err := scanFiles(fss, scanOptions, func(filePath string, c *chunk) error {
+		// config files are small, those read in chunks are not
+		if !c.whole() {
+			return nil
+		}
+
+		fields, ok := configFields(filePath, c.data)
+		if !ok {
+			return nil
+		}
+
+		for _, f := range fields {
+			offset, line := c.position(int(f.Offset))
+			where := fmt.Sprintf("%s:%d [%s]", filePath, line, f.Path)
+
+			for _, k := range g.keywords {
+				if !k.applies(filePath) || !k.re.MatchString(f.Path) || !literalValue(f.Value) {
+					continue
+				}
+
+				if allowlists.allows(k.allowlist, filePath, f.Path, f.Value) {
+					continue
+				}
+
+				results = append(results, Result{
+					PatternID: k.id,
+					Pattern:   k.keyword,
+					Path:      filePath,
+					Content:   f.Value,
+					Comment:   fmt.Sprintf("%s: %s => %q", where, f.Path, f.Value),
+					Offset:    offset,
+					Line:      line,
+					Location:  f.Path,
+					Keyword:   k.keyword,
+					Secret:    f.Value,
+				})
+			}
+
+			// the words rules require are looked for in the key path and
+			// the value, only when some rule requires them
+			var found []bool
+
+			for _, v := range g.values {
+				if !v.rule.Applies(filePath) {
+					continue
+				}
+
+				if g.prefilter != nil && g.prefilter.required[v.id] {
+					if found == nil {
+						found = g.prefilter.found([]byte(f.Path + "\n" + f.Value))
+					}
+
+					if !found[v.id] {
+						continue
+					}
+				}
+
+				for _, match := range v.re.FindAllString(f.Value, -1) {
+					if allowlists.allows(v.rule.Allowlist, filePath, match, "") {
+						continue
+					}
+
+					results = append(results, Result{
+						PatternID: v.id,
+						Pattern:   v.re.String(),
+						Path:      filePath,
+						Content:   match,
+						Comment:   fmt.Sprintf("%s: %s", where, match),
+						Offset:    offset,
+						Line:      line,
+						Location:  f.Path,
+						Keyword:   v.rule.Keyword,
+						Part:      v.rule.Part,
+					})
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return results, err
+}
+
+func (g StructuredGrepper) Release() {}
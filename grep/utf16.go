@@ -0,0 +1,141 @@
+package grep
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// utf16Encoding is how a UTF-16 file is laid out.
+type utf16Encoding struct {
+	order binary.ByteOrder
+	// bom is the length of the byte order mark starting the file.
+	bom int
+}
+
+// detectUTF16 tells whether a file starting with sample is UTF-16 text,
+// either by its BOM or by the layout of its NUL bytes. It returns nil for
+// files in any other encoding.
+func detectUTF16(sample []byte) *utf16Encoding {
+	switch {
+	case bytes.HasPrefix(sample, bomUTF16LE):
+		return &utf16Encoding{order: binary.LittleEndian, bom: len(bomUTF16LE)}
+	case bytes.HasPrefix(sample, bomUTF16BE):
+		return &utf16Encoding{order: binary.BigEndian, bom: len(bomUTF16BE)}
+	}
+
+	sample = sample[:MinInt(len(sample), classifySampleSize)]
+
+	if bytes.IndexByte(sample, 0) == -1 {
+		return nil
+	}
+
+	switch utf16Layout(sample) {
+	case binary.LittleEndian:
+		return &utf16Encoding{order: binary.LittleEndian}
+	case binary.BigEndian:
+		return &utf16Encoding{order: binary.BigEndian}
+	}
+
+	return nil
+}
+
+// transcode converts UTF-16 data to UTF-8.
+func (e *utf16Encoding) transcode(data []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(data))
+
+	tr := &utf16Reader{r: bytes.NewReader(data[e.bom:]), order: e.order}
+	io.Copy(&out, tr)
+
+	return out.Bytes()
+}
+
+// utf16Reader reads UTF-16 text as UTF-8.
+type utf16Reader struct {
+	r     io.Reader
+	order binary.ByteOrder
+
+	in      []byte
+	pending []byte
+	err     error
+}
+
+func (tr *utf16Reader) Read(p []byte) (int, error) {
+	for len(tr.pending) == 0 {
+		if tr.err != nil {
+			return 0, tr.err
+		}
+
+		tr.fill()
+	}
+
+	n := copy(p, tr.pending)
+	tr.pending = tr.pending[n:]
+
+	return n, nil
+}
+
+// fill transcodes the next block of input into pending.
+func (tr *utf16Reader) fill() {
+	const blockSize = 32 << 10
+
+	buf := make([]byte, blockSize)
+	n, err := io.ReadAtLeast(tr.r, buf, 2)
+	tr.in = append(tr.in, buf[:n]...)
+
+	if err != nil {
+		tr.err = err
+		if err == io.ErrUnexpectedEOF {
+			tr.err = io.EOF
+		}
+	}
+
+	units := make([]uint16, 0, len(tr.in)/2)
+	for i := 0; i+1 < len(tr.in); i += 2 {
+		units = append(units, tr.order.Uint16(tr.in[i:]))
+	}
+
+	used := len(units) * 2
+
+	// keep a high surrogate for the block completing it, unless the input
+	// is over
+	if tr.err == nil && len(units) > 0 && utf16.IsSurrogate(rune(units[len(units)-1])) && units[len(units)-1] < 0xdc00 {
+		units = units[:len(units)-1]
+		used -= 2
+	}
+
+	tr.in = tr.in[used:]
+
+	if tr.err != nil {
+		// a trailing odd byte is not text
+		tr.in = nil
+	}
+
+	out := make([]byte, 0, len(units)*utf8.UTFMax)
+	for _, r := range utf16.Decode(units) {
+		out = utf8.AppendRune(out, r)
+	}
+
+	tr.pending = out
+}
+
+// utf16Len returns how many bytes the UTF-8 text data took in UTF-16.
+func utf16Len(data []byte) int64 {
+	var n int64
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		data = data[size:]
+
+		if r >= 0x10000 {
+			n += 4
+		} else {
+			n += 2
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,132 @@
+package grep
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+// encodeUTF16 encodes s as UTF-16 in order, after bom.
+func encodeUTF16(s string, order binary.ByteOrder, bom []byte) []byte {
+	out := append([]byte(nil), bom...)
+
+	for _, unit := range utf16.Encode([]rune(s)) {
+		var b [2]byte
+		order.PutUint16(b[:], unit)
+		out = append(out, b[:]...)
+	}
+
+	return out
+}
+
+func TestDetectUTF16(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		order binary.ByteOrder
+		bom   int
+	}{
+		{"LE BOM", encodeUTF16("a", binary.LittleEndian, bomUTF16LE), binary.LittleEndian, 2},
+		{"BE BOM", encodeUTF16("a", binary.BigEndian, bomUTF16BE), binary.BigEndian, 2},
+		{"LE layout", encodeUTF16("password = hunter2", binary.LittleEndian, nil), binary.LittleEndian, 0},
+		{"BE layout", encodeUTF16("password = hunter2", binary.BigEndian, nil), binary.BigEndian, 0},
+		{"non-ASCII LE", encodeUTF16("clé = ünïcödé value", binary.LittleEndian, nil), binary.LittleEndian, 0},
+		{"UTF-8", []byte("password = hunter2"), nil, 0},
+		{"UTF-8 BOM", append(append([]byte(nil), bomUTF8...), "text"...), nil, 0},
+		{"binary", []byte{0x7f, 'E', 'L', 'F', 0, 0, 0, 0, 1, 0, 0, 0, 0, 2, 0, 3}, nil, 0},
+		{"short", []byte{'a', 0}, nil, 0},
+	}
+
+	for _, test := range tests {
+		e := detectUTF16(test.data)
+
+		switch {
+		case e == nil && test.order != nil:
+			t.Errorf("%s: not detected as UTF-16", test.name)
+		case e != nil && test.order == nil:
+			t.Errorf("%s: detected as UTF-16 %s", test.name, e.order)
+		case e != nil && (e.order != test.order || e.bom != test.bom):
+			t.Errorf("%s: detected as UTF-16 %s with a %d byte BOM, want %s with %d", test.name, e.order, e.bom, test.order, test.bom)
+		}
+	}
+}
+
+func TestUTF16Reader(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		order binary.ByteOrder
+		want  string
+	}{
+		{"ASCII", encodeUTF16("key=value\r\n", binary.LittleEndian, nil), binary.LittleEndian, "key=value\r\n"},
+		{"BE", encodeUTF16("key=value", binary.BigEndian, nil), binary.BigEndian, "key=value"},
+		{"surrogates", encodeUTF16("a😀b🔑", binary.LittleEndian, nil), binary.LittleEndian, "a😀b🔑"},
+		{"odd byte", append(encodeUTF16("ab", binary.LittleEndian, nil), 'c'), binary.LittleEndian, "ab"},
+		{"lone surrogate", []byte{0x00, 0xd8, 'a', 0}, binary.LittleEndian, "\ufffda"},
+		{"empty", nil, binary.LittleEndian, ""},
+	}
+
+	for _, test := range tests {
+		order := test.order
+
+		// reading a byte at a time splits surrogate pairs across blocks
+		for _, r := range []func([]byte) *utf16Reader{
+			func(data []byte) *utf16Reader {
+				return &utf16Reader{r: bytes.NewReader(data), order: order}
+			},
+			func(data []byte) *utf16Reader {
+				return &utf16Reader{r: iotest.OneByteReader(bytes.NewReader(data)), order: order}
+			},
+		} {
+			out, err := ioutil.ReadAll(r(test.data))
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+				continue
+			}
+
+			if string(out) != test.want {
+				t.Errorf("%s: read %q, want %q", test.name, out, test.want)
+			}
+		}
+	}
+}
+
+func TestUTF16Matches(t *testing.T) {
+	text := "line one\nsecret: KEY1234 😀\nline three KEY5678\n"
+
+	tests := []struct {
+		name    string
+		data    []byte
+		options []GrepOption
+		offsets []int64
+	}{
+		{"LE BOM", encodeUTF16(text, binary.LittleEndian, bomUTF16LE), nil, []int64{2 + 2*17, 2 + 2*(28+11)}},
+		{"BE", encodeUTF16(text, binary.BigEndian, nil), nil, []int64{2 * 17, 2 * (28 + 11)}},
+		{"LE chunked", encodeUTF16(text, binary.LittleEndian, bomUTF16LE), []GrepOption{WithChunkSize(16, 8)}, []int64{2 + 2*17, 2 + 2*(28+11)}},
+	}
+
+	for _, test := range tests {
+		files := fstest.MapFS{"file.txt": {Data: test.data}}
+
+		results, err := NewReGrepper([]*regexp.Regexp{regexp.MustCompile(`KEY[0-9]+`)}).Grep(files, test.options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != len(test.offsets) {
+			t.Errorf("%s: found %d matches, want %d", test.name, len(results), len(test.offsets))
+			continue
+		}
+
+		for i, result := range results {
+			if result.Offset != test.offsets[i] || result.Line != i+2 {
+				t.Errorf("%s: match %q at %d:%d, want %d:%d", test.name, result.Content, result.Offset, result.Line, test.offsets[i], i+2)
+			}
+		}
+	}
+}
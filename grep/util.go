@@ -4,6 +4,7 @@ package grep
 
 import (
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
@@ -138,8 +139,40 @@ func ReadFile(fss interface{}, path string) ([]byte, error) {
 			return nil, err
 		}
 
+		defer fd.Close()
+
 		return ioutil.ReadAll(fd)
 	}
 
 	return nil, ErrInvalidFS
 }
+
+// OpenFile opens a file of either a billy.Filesystem or a fs.FS for
+// reading.
+func OpenFile(fss interface{}, path string) (io.ReadCloser, error) {
+	switch f := fss.(type) {
+	case billy.Basic:
+		return f.Open(path)
+	case fs.FS:
+		return f.Open(path)
+	default:
+		return nil, ErrInvalidFS
+	}
+}
+
+// ReadFileLimit reads up to limit bytes of a file. A limit of zero reads the
+// whole file.
+func ReadFileLimit(fss interface{}, path string, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return ReadFile(fss, path)
+	}
+
+	fd, err := OpenFile(fss, path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return ioutil.ReadAll(io.LimitReader(fd, limit))
+}
@@ -0,0 +1,175 @@
+package grep
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// heredocStart matches the opening of a shell or Ruby style heredoc, e.g.
+// <<EOF, <<-'EOF' or <<~EOF.
+var heredocStart = regexp.MustCompile(`^<<[-~]?\s*(["']?)([A-Za-z_][A-Za-z0-9_]*)(["']?)`)
+
+// variableReference matches values taking their contents from somewhere else,
+// like environment variables or templating engines.
+var variableReference = regexp.MustCompile(`^(?:\$[{(A-Za-z_].*|\{\{.*\}\}|%\([A-Za-z_][A-Za-z0-9_]*\)s|%[A-Za-z_][A-Za-z0-9_]*%|<%=?.*%>|#\{.*\})$`)
+
+// codeReference matches unquoted values which are code reading the value from
+// elsewhere: calls and indexing, e.g. os.Getenv("KEY") or secrets["key"], and
+// fields of objects commonly holding settings, e.g. config.password. Other
+// dotted values, like db.prod.internal, may well be literals.
+var codeReference = regexp.MustCompile(`^(?:[A-Za-z_][A-Za-z0-9_]*(?:\.[A-Za-z_][A-Za-z0-9_]*)*[(\[]|(?i:self|this|cls|process\.env|os\.environ|env|config|conf|cfg|settings|secrets|options|opts|params|props|args|credentials|creds|ctx|context)\.[A-Za-z_][A-Za-z0-9_.]*$)`)
+
+// placeholderWords are found in values standing in for secrets in
+// documentation, samples and tests.
+var placeholderWords = []string{
+	"changeme", "change_me", "changeit", "placeholder", "example", "sample",
+	"dummy", "redacted", "your_", "your-", "yourkey", "foobar", "xxxx",
+}
+
+// placeholderValue matches values which obviously are not real secrets: empty
+// ones, placeholder words alone and masked values.
+var placeholderValue = regexp.MustCompile(`(?i)^(?:null|nil|none|undefined|true|false|todo|tbd|` +
+	strings.Join(placeholderWords, "|") +
+	`|x{3,}|\*+|\.{3,}|…|<[^>]*>|\[[^\]]*\]|(?:your|my|insert|enter)[-_ ].*)$`)
+
+// assignedValue returns the literal assigned at the end of a keyword match,
+// which ends at pos of data. Quoted strings, heredocs and bare tokens are
+// understood. ok is false when there is no literal value to report: nothing
+// is assigned, or the value is a variable reference or a placeholder. cut
+// tells whether data stops before the end of the file, in which case values
+// running up to its end may be cut, and are not reported either.
+func assignedValue(data []byte, pos int, cut bool) (value string, ok bool) {
+	quoted := true
+
+	switch {
+	// the template took the opening quote, e.g. password="
+	case pos > 0 && (data[pos-1] == '"' || data[pos-1] == '\'') && (pos < 2 || bytes.IndexByte([]byte("=: \t"), data[pos-2]) != -1):
+		value = quotedValue(data[pos-1:])
+
+	// the template took an opening tag, e.g. <password>
+	case pos > 0 && data[pos-1] == '>':
+		end := bytes.IndexByte(data[pos:], '<')
+		if end == -1 {
+			return "", false
+		}
+
+		value = strings.TrimSpace(string(data[pos : pos+end]))
+
+	default:
+		rest := bytes.TrimLeft(data[pos:], " \t")
+
+		switch {
+		case len(rest) == 0:
+			return "", false
+
+		case rest[0] == '"' || rest[0] == '\'' || rest[0] == '`':
+			value = quotedValue(rest)
+
+		case bytes.HasPrefix(rest, []byte("<<")):
+			value = heredocValue(rest)
+
+		default:
+			if cut && bytes.IndexAny(rest, " \t\r\n,;") == -1 {
+				return "", false
+			}
+
+			quoted = false
+			value = bareValue(rest)
+		}
+	}
+
+	value = strings.TrimSpace(value)
+
+	if !literalValue(value) || (!quoted && codeReference.MatchString(value)) {
+		return "", false
+	}
+
+	return value, true
+}
+
+// literalValue tells whether value is worth reporting as a secret: it is not
+// empty, a variable reference nor a placeholder.
+func literalValue(value string) bool {
+	return value != "" && !variableReference.MatchString(value) && !placeholderValue.MatchString(value)
+}
+
+// quotedValue returns the contents of the string literal data starts with.
+// Only triple quoted and backquoted strings span lines.
+func quotedValue(data []byte) string {
+	quote := data[0]
+
+	for _, triple := range []string{`"""`, `'''`} {
+		if bytes.HasPrefix(data, []byte(triple)) {
+			end := bytes.Index(data[3:], []byte(triple))
+			if end == -1 {
+				return ""
+			}
+
+			return string(data[3 : 3+end])
+		}
+	}
+
+	var value []byte
+
+	for i := 1; i < len(data); i++ {
+		b := data[i]
+
+		switch {
+		case b == quote:
+			return string(value)
+
+		case b == '\n' && quote != '`':
+			// not closed on its line, not a string literal
+			return ""
+
+		case b == '\\' && quote != '`' && i+1 < len(data):
+			i++
+			value = append(value, data[i])
+
+		default:
+			value = append(value, b)
+		}
+	}
+
+	return ""
+}
+
+// heredocValue returns the body of the heredoc data starts with, made of the
+// lines after the current one until its terminator.
+func heredocValue(data []byte) string {
+	m := heredocStart.FindSubmatch(data)
+	if m == nil || string(m[1]) != string(m[3]) {
+		return ""
+	}
+
+	terminator := string(m[2])
+
+	start := bytes.IndexByte(data, '\n')
+	if start == -1 {
+		return ""
+	}
+
+	var lines []string
+
+	for _, line := range strings.SplitAfter(string(data[start+1:]), "\n") {
+		if strings.TrimSpace(line) == terminator {
+			return strings.Join(lines, "")
+		}
+
+		lines = append(lines, line)
+	}
+
+	// the heredoc goes on past the data available
+	return ""
+}
+
+// bareValue returns the unquoted token data starts with.
+func bareValue(data []byte) string {
+	end := bytes.IndexAny(data, " \t\r\n,;")
+	if end == -1 {
+		end = len(data)
+	}
+
+	return strings.TrimRight(string(data[:end]), ")]}")
+}
@@ -0,0 +1,54 @@
+package grep
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssignedValue(t *testing.T) {
+	tests := []struct {
+		// data is matched up to the | it holds
+		data  string
+		cut   bool
+		value string
+		ok    bool
+	}{
+		{`password = |"hunter2"`, false, "hunter2", true},
+		{`password="|hunter2" # c`, false, "hunter2", true},
+		{`password: |'it''s'`, false, "it", true},
+		{`<password>| hunter2 </password>`, false, "hunter2", true},
+		{"password = |hunter2\nnext", false, "hunter2", true},
+		{"password = |<<EOF\nline one\nEOF\n", false, "line one", true},
+		{`password = |"unclosed`, false, "", false},
+		{"password = |\n", false, "", false},
+
+		{`host = |db.prod.internal`, false, "db.prod.internal", true},
+		{`password = |s3cr3t.pass`, false, "s3cr3t.pass", true},
+		{`password = |config.password`, false, "", false},
+		{`password = |Settings.Password`, false, "", false},
+		{`password = |process.env.DB_PASSWORD`, false, "", false},
+		{`password = |os.Getenv("DB_PASSWORD")`, false, "", false},
+		{`password = |secrets["db"]`, false, "", false},
+		{`password = |getPassword()`, false, "", false},
+		{`password = |"config.password"`, false, "config.password", true},
+
+		{`password = |${DB_PASSWORD}`, false, "", false},
+		{`password = |changeme`, false, "", false},
+		{`password = |"<your password>"`, false, "", false},
+
+		// values running up to the end of a chunk may be cut
+		{`password = |hunter`, true, "", false},
+		{`password = |hunter2 `, true, "hunter2", true},
+		{`password = |hunter2`, false, "hunter2", true},
+	}
+
+	for _, test := range tests {
+		pos := strings.IndexByte(test.data, '|')
+		data := []byte(test.data[:pos] + test.data[pos+1:])
+
+		value, ok := assignedValue(data, pos, test.cut)
+		if value != test.value || ok != test.ok {
+			t.Errorf("%q: got %q, %v, want %q, %v", test.data, value, ok, test.value, test.ok)
+		}
+	}
+}